@@ -0,0 +1,356 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// AccessState 是运行时通过管理员命令修改的访问列表，与配置文件中的列表合并生效
+type AccessState struct {
+    Users    map[string]*AccessEntry   `json:"users,omitempty"`    // 按用户或群组 ID
+    Channels map[string]*AccessEntry   `json:"channels,omitempty"` // 按频道用户名，格式为小写的 "@name"
+    Revoked  map[string]bool           `json:"revoked,omitempty"`  // 被 /deny 撤销的配置文件条目
+    Banned   map[string]*AccessEntry   `json:"banned,omitempty"`
+    Requests map[string]*AccessRequest `json:"requests,omitempty"` // 未授权用户的使用申请，按用户 ID
+    Invites  map[string]*Invite        `json:"invites,omitempty"`  // 按邀请码
+}
+
+// AccessEntry 记录谁在什么时候添加了条目
+type AccessEntry struct {
+    Note    string       `json:"note,omitempty"`
+    AddedBy int64        `json:"added_by,omitempty"`
+    AddedAt time.Time    `json:"added_at"`
+    Role    string       `json:"role,omitempty"`
+    Quota   *QuotaLimits `json:"quota,omitempty"`
+}
+
+// accessLocked 返回访问列表，不存在时创建，调用方需持有 stateMu
+func accessLocked() *AccessState {
+    if state.Access == nil {
+        state.Access = &AccessState{}
+    }
+    a := state.Access
+    if a.Users == nil {
+        a.Users = map[string]*AccessEntry{}
+    }
+    if a.Channels == nil {
+        a.Channels = map[string]*AccessEntry{}
+    }
+    if a.Revoked == nil {
+        a.Revoked = map[string]bool{}
+    }
+    if a.Banned == nil {
+        a.Banned = map[string]*AccessEntry{}
+    }
+    if a.Requests == nil {
+        a.Requests = map[string]*AccessRequest{}
+    }
+    if a.Invites == nil {
+        a.Invites = map[string]*Invite{}
+    }
+    return a
+}
+
+// channelAccessKey 统一频道用户名的写法
+func channelAccessKey(username string) string {
+    return "@" + strings.ToLower(strings.TrimPrefix(username, "@"))
+}
+
+// adminIDs 返回管理员列表，未配置 admins 时沿用 allowed_users
+func adminIDs() []int64 {
+    if len(config.Admins) > 0 {
+        return config.Admins
+    }
+    return config.AllowedUsers
+}
+
+func isAdmin(userID int64) bool {
+    for _, id := range adminIDs() {
+        if id == userID {
+            return true
+        }
+    }
+    return false
+}
+
+// isBanned 判断用户或聊天是否被封禁
+func isBanned(id int64) bool {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    return accessLocked().Banned[chatKey(id)] != nil
+}
+
+// accessOpen 判断是否为开放模式：配置文件中没有任何访问限制时所有人都可以使用
+func accessOpen() bool {
+    return len(config.AllowedUsers) == 0 && len(config.AllowedChannels) == 0
+}
+
+// accessListedLocked 判断 ID 或频道是否在访问列表中（配置文件或运行时添加），调用方需持有 stateMu
+func accessListedLocked(chatID int64, chatUsername string) bool {
+    a := accessLocked()
+    key := chatKey(chatID)
+    if a.Users[key] != nil {
+        return true
+    }
+    if !a.Revoked[key] {
+        for _, id := range config.AllowedUsers {
+            if id == chatID {
+                return true
+            }
+        }
+    }
+    if chatUsername == "" {
+        return false
+    }
+    channel := channelAccessKey(chatUsername)
+    if a.Channels[channel] != nil {
+        return true
+    }
+    if !a.Revoked[channel] {
+        for _, c := range config.AllowedChannels {
+            if c == chatUsername {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// accessListed 判断 ID 或频道是否被明确允许，不考虑开放模式
+func accessListed(chatID int64, chatUsername string) bool {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if accessLocked().Banned[chatKey(chatID)] != nil {
+        return false
+    }
+    return accessListedLocked(chatID, chatUsername)
+}
+
+// isAllowed 判断用户、群组或频道是否可以使用机器人：封禁优先，其次是管理员、开放模式和访问列表
+func isAllowed(chatID int64, chatUsername string) bool {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if accessLocked().Banned[chatKey(chatID)] != nil {
+        return false
+    }
+    if isAdmin(chatID) || accessOpen() {
+        return true
+    }
+    return accessListedLocked(chatID, chatUsername)
+}
+
+// accessTarget 解析管理员命令的目标：数字 ID、@频道名，或在群里回复目标用户的消息
+func accessTarget(message *tgbotapi.Message) (int64, string, string, bool) {
+    fields := strings.Fields(message.CommandArguments())
+    note := ""
+    if len(fields) > 1 {
+        note = strings.Join(fields[1:], " ")
+    }
+    if len(fields) == 0 {
+        if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil {
+            from := message.ReplyToMessage.From
+            return from.ID, "", strings.TrimSpace(from.FirstName + " " + from.LastName), true
+        }
+        return 0, "", "", false
+    }
+    if strings.HasPrefix(fields[0], "@") {
+        return 0, fields[0], note, true
+    }
+    id, err := strconv.ParseInt(fields[0], 10, 64)
+    if err != nil {
+        return 0, "", "", false
+    }
+    return id, "", note, true
+}
+
+// handleAccessCommand 处理 /allow、/deny、/ban 和 /users，只有管理员可以使用
+func handleAccessCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    chatID := message.Chat.ID
+    threadID := messageThreadID(message)
+    reply := func(text string) {
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, text), threadID)
+    }
+    if message.From == nil || !isAdmin(message.From.ID) {
+        reply("只有管理员可以使用此命令")
+        return
+    }
+    command := message.Command()
+    if command == "users" {
+        reply(accessSummary())
+        return
+    }
+
+    id, channel, note, ok := accessTarget(message)
+    if !ok {
+        switch command {
+        case "ban":
+            reply("用法：/ban <用户ID> [原因]，或在群里回复该用户的消息")
+        default:
+            reply(fmt.Sprintf("用法：/%s <用户或群组ID | @频道名> [备注]，或在群里回复该用户的消息", command))
+        }
+        return
+    }
+    if command == "ban" && channel != "" {
+        reply("只能封禁用户或群组 ID")
+        return
+    }
+    if command != "allow" && channel == "" && isAdmin(id) {
+        reply("管理员始终可以使用，请先从配置文件的 admins 中移除")
+        return
+    }
+
+    key := chatKey(id)
+    label := key
+    if channel != "" {
+        key = channelAccessKey(channel)
+        label = key
+    }
+    entry := &AccessEntry{Note: note, AddedBy: message.From.ID, AddedAt: time.Now()}
+
+    var text string
+    stateMu.Lock()
+    a := accessLocked()
+    switch command {
+    case "allow":
+        delete(a.Revoked, key)
+        delete(a.Banned, key)
+        if channel != "" {
+            a.Channels[key] = entry
+        } else {
+            a.Users[key] = entry
+        }
+        text = "✅ 已允许 " + label
+        if accessOpen() {
+            text += "\n提示：配置文件中未设置 allowed_users 和 allowed_channels，目前所有人都可以使用"
+        }
+    case "deny":
+        delete(a.Users, key)
+        delete(a.Channels, key)
+        a.Revoked[key] = true
+        text = "🚫 已移除 " + label + " 的使用权限"
+    case "ban":
+        delete(a.Users, key)
+        a.Banned[key] = entry
+        text = "⛔ 已封禁 " + label + "，使用 /allow 可解除"
+    }
+    saveStateLocked()
+    stateMu.Unlock()
+
+    logEvent("AccessChanged", map[string]interface{}{
+        "command": command,
+        "target":  label,
+        "note":    note,
+        "by":      message.From.ID,
+    })
+    reply(text)
+}
+
+// accessSummary 列出管理员、访问列表和封禁列表
+func accessSummary() string {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    a := accessLocked()
+
+    var sb strings.Builder
+    sb.WriteString("👥 访问控制\n")
+    sb.WriteString("──────────────\n")
+    if accessOpen() {
+        sb.WriteString("模式：开放（未配置 allowed_users 和 allowed_channels）\n")
+    }
+
+    var admins []string
+    for _, id := range adminIDs() {
+        admins = append(admins, chatKey(id))
+    }
+    sb.WriteString("🛡 管理员: " + strings.Join(admins, ", ") + "\n")
+
+    var listed []string
+    for _, id := range config.AllowedUsers {
+        if key := chatKey(id); !a.Revoked[key] && a.Users[key] == nil {
+            listed = append(listed, key+"（配置）")
+        }
+    }
+    for _, c := range config.AllowedChannels {
+        if key := channelAccessKey(c); !a.Revoked[key] && a.Channels[key] == nil {
+            listed = append(listed, key+"（配置）")
+        }
+    }
+    listed = append(listed, formatAccessEntries(a.Users)...)
+    listed = append(listed, formatAccessEntries(a.Channels)...)
+    sb.WriteString(fmt.Sprintf("✅ 允许 (%d):\n", len(listed)))
+    for _, line := range listed {
+        sb.WriteString("    " + line + "\n")
+    }
+
+    pending := 0
+    for _, r := range a.Requests {
+        if r.Status == requestPending {
+            pending++
+        }
+    }
+    if pending > 0 {
+        sb.WriteString(fmt.Sprintf("📨 待审核申请: %d\n", pending))
+    }
+
+    banned := formatAccessEntries(a.Banned)
+    sb.WriteString(fmt.Sprintf("⛔ 封禁 (%d):\n", len(banned)))
+    for _, line := range banned {
+        sb.WriteString("    " + line + "\n")
+    }
+    sb.WriteString("──────────────")
+    return sb.String()
+}
+
+func formatAccessEntries(entries map[string]*AccessEntry) []string {
+    keys := make([]string, 0, len(entries))
+    for key := range entries {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    lines := make([]string, 0, len(keys))
+    for _, key := range keys {
+        e := entries[key]
+        line := key
+        if e.Note != "" {
+            line += " " + e.Note
+        }
+        if e.Role != "" {
+            line += " · 角色 " + e.Role
+        }
+        if e.Quota != nil {
+            line += " · 额度 " + e.Quota.summary()
+        }
+        line += " · " + e.AddedAt.In(defaultLocation()).Format("01-02 15:04")
+        lines = append(lines, line)
+    }
+    return lines
+}
+
+// adminBotCommands 是只显示给管理员的命令
+var adminBotCommands = []tgbotapi.BotCommand{
+    {
+        Command:     "allow",
+        Description: "允许用户、群组或频道使用：/allow <ID|@频道>",
+    },
+    {
+        Command:     "deny",
+        Description: "移除使用权限：/deny <ID|@频道>",
+    },
+    {
+        Command:     "ban",
+        Description: "封禁用户：/ban <ID> [原因]",
+    },
+    {
+        Command:     "users",
+        Description: "查看访问列表",
+    },
+    {
+        Command:     "invite",
+        Description: "生成邀请链接：/invite [uses=次数] [days=天数]",
+    },
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// AccessRequestsConfig 配置未授权用户的使用申请
+type AccessRequestsConfig struct {
+    Disabled        bool        `yaml:"disabled"`
+    CooldownMinutes int         `yaml:"cooldown_minutes"`
+    Quota           QuotaLimits `yaml:"quota"`
+}
+
+// AccessRequest 是一条使用申请及其处理结果
+type AccessRequest struct {
+    Name        string    `json:"name"`
+    Username    string    `json:"username,omitempty"`
+    Status      string    `json:"status"`
+    RequestedAt time.Time `json:"requested_at"`
+    DecidedBy   int64     `json:"decided_by,omitempty"`
+    DecidedAt   time.Time `json:"decided_at,omitempty"`
+}
+
+const (
+    requestPending  = "pending"
+    requestApproved = "approved"
+    requestDenied   = "denied"
+)
+
+var (
+    requestPromptMu sync.Mutex
+    requestPrompted = map[int64]time.Time{} // 用户 ID -> 上次提示申请的时间
+)
+
+func accessRequestCooldown() time.Duration {
+    if config.AccessRequests.CooldownMinutes > 0 {
+        return time.Duration(config.AccessRequests.CooldownMinutes) * time.Minute
+    }
+    return time.Hour
+}
+
+func userDisplayName(user *tgbotapi.User) string {
+    name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+    if name == "" {
+        name = chatKey(user.ID)
+    }
+    return name
+}
+
+// offerAccessRequest 向私聊中的未授权用户提供申请按钮；已被拒绝或冷却时间内提示过的用户不再提示
+func offerAccessRequest(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    if config.AccessRequests.Disabled || message.From == nil || message.Chat.Type != "private" {
+        return
+    }
+    userID := message.From.ID
+
+    stateMu.Lock()
+    request := accessLocked().Requests[chatKey(userID)]
+    var status string
+    if request != nil {
+        status = request.Status
+    }
+    stateMu.Unlock()
+    if status == requestDenied {
+        return
+    }
+
+    requestPromptMu.Lock()
+    last, prompted := requestPrompted[userID]
+    if prompted && time.Since(last) < accessRequestCooldown() {
+        requestPromptMu.Unlock()
+        return
+    }
+    requestPrompted[userID] = time.Now()
+    requestPromptMu.Unlock()
+
+    text := "🔒 你还没有使用此机器人的权限。"
+    var markup interface{}
+    if status == requestPending {
+        text += "\n你的申请正在等待管理员审核。"
+    } else {
+        text += "\n可以点击下方按钮向管理员申请。"
+        markup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+            tgbotapi.NewInlineKeyboardButtonData("📨 申请使用", "acc:req"),
+        ))
+    }
+    msg := tgbotapi.NewMessage(message.Chat.ID, text)
+    msg.ReplyMarkup = markup
+    if _, err := bot.Send(msg); err != nil {
+        logEvent("SendAccessRequestOfferError", err)
+    }
+}
+
+// handleAccessRequestCallback 处理申请按钮和管理员的审核按钮，回调数据格式为 "acc:req" 或 "acc:<ok|quota|no>:<用户ID>"
+func handleAccessRequestCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    parts := strings.Split(query.Data, ":")
+    if len(parts) == 2 && parts[1] == "req" {
+        submitAccessRequest(bot, query)
+        return
+    }
+    if len(parts) != 3 {
+        return
+    }
+    if !isAdmin(query.From.ID) {
+        bot.Request(tgbotapi.NewCallback(query.ID, "只有管理员可以审核"))
+        return
+    }
+    userID, err := strconv.ParseInt(parts[2], 10, 64)
+    if err != nil {
+        return
+    }
+    decideAccessRequest(bot, query, userID, parts[1])
+}
+
+func submitAccessRequest(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    user := query.From
+    if config.AccessRequests.Disabled || isAllowed(user.ID, user.UserName) {
+        bot.Request(tgbotapi.NewCallback(query.ID, ""))
+        return
+    }
+    key := chatKey(user.ID)
+
+    stateMu.Lock()
+    a := accessLocked()
+    existing := a.Requests[key]
+    duplicate := existing != nil && existing.Status != requestApproved
+    if !duplicate {
+        a.Requests[key] = &AccessRequest{
+            Name:        userDisplayName(user),
+            Username:    user.UserName,
+            Status:      requestPending,
+            RequestedAt: time.Now(),
+        }
+        saveStateLocked()
+    }
+    stateMu.Unlock()
+
+    edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, "📨 申请已提交，管理员审核后会通知你。")
+    if _, err := bot.Send(edit); err != nil {
+        logEvent("EditAccessRequestOfferError", err)
+    }
+    bot.Request(tgbotapi.NewCallback(query.ID, "申请已提交"))
+    if duplicate {
+        return
+    }
+
+    logEvent("AccessRequested", map[string]interface{}{
+        "userID":   user.ID,
+        "username": user.UserName,
+    })
+    var sb strings.Builder
+    sb.WriteString("📨 新的使用申请\n")
+    sb.WriteString("──────────────\n")
+    sb.WriteString(fmt.Sprintf("👤 姓名: %s\n", userDisplayName(user)))
+    if user.UserName != "" {
+        sb.WriteString(fmt.Sprintf("🔗 用户名: @%s\n", user.UserName))
+    }
+    sb.WriteString(fmt.Sprintf("🆔 ID: %d\n", user.ID))
+    if user.LanguageCode != "" {
+        sb.WriteString(fmt.Sprintf("🌐 语言: %s\n", user.LanguageCode))
+    }
+    sb.WriteString("──────────────")
+
+    id := strconv.FormatInt(user.ID, 10)
+    row := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("✅ 批准", "acc:ok:"+id)}
+    if config.AccessRequests.Quota != (QuotaLimits{}) {
+        row = append(row, tgbotapi.NewInlineKeyboardButtonData("🎫 限额批准（"+config.AccessRequests.Quota.summary()+"）", "acc:quota:"+id))
+    }
+    row = append(row, tgbotapi.NewInlineKeyboardButtonData("❌ 拒绝", "acc:no:"+id))
+    markup := tgbotapi.NewInlineKeyboardMarkup(row)
+    for _, adminID := range adminIDs() {
+        msg := tgbotapi.NewMessage(adminID, sb.String())
+        msg.ReplyMarkup = markup
+        if _, err := bot.Send(msg); err != nil {
+            logEvent("NotifyAdminError", map[string]interface{}{
+                "userID": adminID,
+                "error":  err.Error(),
+            })
+        }
+    }
+}
+
+// decideAccessRequest 保存审核结果，更新管理员的消息并通知申请人
+func decideAccessRequest(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, userID int64, decision string) {
+    if decision != "ok" && decision != "quota" && decision != "no" {
+        bot.Request(tgbotapi.NewCallback(query.ID, "无效操作"))
+        return
+    }
+    key := chatKey(userID)
+    now := time.Now()
+
+    stateMu.Lock()
+    a := accessLocked()
+    request := a.Requests[key]
+    if request == nil || request.Status != requestPending {
+        stateMu.Unlock()
+        bot.Request(tgbotapi.NewCallback(query.ID, "该申请已处理"))
+        return
+    }
+    request.DecidedBy, request.DecidedAt = query.From.ID, now
+    var quota *QuotaLimits
+    switch decision {
+    case "ok", "quota":
+        request.Status = requestApproved
+        entry := &AccessEntry{Note: request.Name, AddedBy: query.From.ID, AddedAt: now}
+        if decision == "quota" {
+            q := config.AccessRequests.Quota
+            quota = &q
+            entry.Quota = quota
+        }
+        a.Users[key] = entry
+        delete(a.Revoked, key)
+    case "no":
+        request.Status = requestDenied
+    }
+    name, approved := request.Name, request.Status == requestApproved
+    saveStateLocked()
+    stateMu.Unlock()
+
+    logEvent("AccessRequestDecided", map[string]interface{}{
+        "userID":   userID,
+        "decision": decision,
+        "by":       query.From.ID,
+    })
+
+    var status, notice string
+    if !approved {
+        status = "❌ 已拒绝"
+        notice = "❌ 很抱歉，你的使用申请未通过。"
+    } else {
+        status = "✅ 已批准"
+        notice = "✅ 你的使用申请已通过，现在可以开始对话了。"
+        if quota != nil {
+            status += "（" + quota.summary() + "）"
+            notice += "\n额度：" + quota.summary()
+        }
+    }
+    text := query.Message.Text + "\n\n" + status + "（" + userDisplayName(query.From) + "）"
+    if _, err := bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)); err != nil {
+        logEvent("EditAccessRequestError", err)
+    }
+    bot.Request(tgbotapi.NewCallback(query.ID, status))
+    if _, err := bot.Send(tgbotapi.NewMessage(userID, notice)); err != nil {
+        logEvent("NotifyRequesterError", map[string]interface{}{
+            "userID": userID,
+            "name":   name,
+            "error":  err.Error(),
+        })
+    }
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+)
+
+// 熔断器状态
+type breakerState int
+
+const (
+    breakerClosed breakerState = iota
+    breakerOpen
+    breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+    switch s {
+    case breakerOpen:
+        return "open"
+    case breakerHalfOpen:
+        return "half-open"
+    default:
+        return "closed"
+    }
+}
+
+// 状态的中文描述，用于 /status 和管理员通知
+func (s breakerState) Label() string {
+    switch s {
+    case breakerOpen:
+        return "🔴 熔断中"
+    case breakerHalfOpen:
+        return "🟡 半开探测"
+    default:
+        return "🟢 正常"
+    }
+}
+
+// upstreamError 表示上游不可用（网络错误、超时、5xx），只有这类错误会计入熔断失败次数
+type upstreamError struct {
+    msg string
+}
+
+func (e *upstreamError) Error() string {
+    return e.msg
+}
+
+func isUpstreamFailure(err error) bool {
+    var ue *upstreamError
+    return errors.As(err, &ue)
+}
+
+// circuitOpenError 在熔断期间快速失败时返回
+type circuitOpenError struct {
+    endpoint   string
+    retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+    seconds := int(e.retryAfter.Seconds()) + 1
+    return fmt.Sprintf("上游服务暂时不可用（%s 熔断保护中），请约 %d 秒后再试", e.endpoint, seconds)
+}
+
+type CircuitBreaker struct {
+    mu               sync.Mutex
+    endpoint         string
+    state            breakerState
+    failures         int
+    openedAt         time.Time
+    halfOpenInFlight int
+    lastError        string
+    lastChange       time.Time
+}
+
+type breakerSnapshot struct {
+    Endpoint   string
+    State      breakerState
+    Failures   int
+    RetryAfter time.Duration
+    LastError  string
+    LastChange time.Time
+}
+
+var (
+    breakersMu sync.Mutex
+    breakers   = map[string]*CircuitBreaker{}
+    // 状态变化回调，由 main 在机器人初始化后设置
+    breakerStateHook func(endpoint string, from, to breakerState, lastError string)
+)
+
+func getBreaker(endpoint string) *CircuitBreaker {
+    breakersMu.Lock()
+    defer breakersMu.Unlock()
+    cb, ok := breakers[endpoint]
+    if !ok {
+        cb = &CircuitBreaker{endpoint: endpoint, lastChange: time.Now()}
+        breakers[endpoint] = cb
+    }
+    return cb
+}
+
+func breakerSnapshots() []breakerSnapshot {
+    breakersMu.Lock()
+    list := make([]*CircuitBreaker, 0, len(breakers))
+    for _, cb := range breakers {
+        list = append(list, cb)
+    }
+    breakersMu.Unlock()
+
+    snapshots := make([]breakerSnapshot, 0, len(list))
+    for _, cb := range list {
+        snapshots = append(snapshots, cb.Snapshot())
+    }
+    sort.Slice(snapshots, func(i, j int) bool {
+        return snapshots[i].Endpoint < snapshots[j].Endpoint
+    })
+    return snapshots
+}
+
+func breakerThreshold() int {
+    if config.CircuitBreaker.FailureThreshold > 0 {
+        return config.CircuitBreaker.FailureThreshold
+    }
+    return 5
+}
+
+func breakerOpenDuration() time.Duration {
+    if config.CircuitBreaker.OpenSeconds > 0 {
+        return time.Duration(config.CircuitBreaker.OpenSeconds) * time.Second
+    }
+    return 60 * time.Second
+}
+
+func breakerHalfOpenMax() int {
+    if config.CircuitBreaker.HalfOpenRequests > 0 {
+        return config.CircuitBreaker.HalfOpenRequests
+    }
+    return 1
+}
+
+// Allow 判断是否允许发出请求，熔断期间返回 circuitOpenError
+func (cb *CircuitBreaker) Allow() error {
+    if config.CircuitBreaker.Disabled {
+        return nil
+    }
+
+    cb.mu.Lock()
+    var from breakerState
+    changed := false
+
+    if cb.state == breakerOpen {
+        elapsed := time.Since(cb.openedAt)
+        if elapsed < breakerOpenDuration() {
+            retryAfter := breakerOpenDuration() - elapsed
+            cb.mu.Unlock()
+            return &circuitOpenError{endpoint: cb.endpoint, retryAfter: retryAfter}
+        }
+        from, changed = cb.transition(breakerHalfOpen)
+    }
+
+    if cb.state == breakerHalfOpen {
+        if cb.halfOpenInFlight >= breakerHalfOpenMax() {
+            cb.mu.Unlock()
+            return &circuitOpenError{endpoint: cb.endpoint, retryAfter: time.Second}
+        }
+        cb.halfOpenInFlight++
+    }
+    lastError := cb.lastError
+    cb.mu.Unlock()
+
+    if changed {
+        cb.fireHook(from, breakerHalfOpen, lastError)
+    }
+    return nil
+}
+
+// Record 记录一次请求结果；非上游故障的错误（如参数错误）视为上游健康
+func (cb *CircuitBreaker) Record(err error) {
+    if config.CircuitBreaker.Disabled {
+        return
+    }
+
+    cb.mu.Lock()
+    var from, to breakerState
+    changed := false
+
+    if cb.state == breakerHalfOpen && cb.halfOpenInFlight > 0 {
+        cb.halfOpenInFlight--
+    }
+
+    if err != nil && isUpstreamFailure(err) {
+        cb.failures++
+        cb.lastError = err.Error()
+        if cb.state == breakerHalfOpen || (cb.state == breakerClosed && cb.failures >= breakerThreshold()) {
+            cb.openedAt = time.Now()
+            to = breakerOpen
+            from, changed = cb.transition(breakerOpen)
+        }
+    } else {
+        cb.failures = 0
+        if cb.state != breakerClosed {
+            to = breakerClosed
+            from, changed = cb.transition(breakerClosed)
+        }
+    }
+    lastError := cb.lastError
+    cb.mu.Unlock()
+
+    if changed {
+        cb.fireHook(from, to, lastError)
+    }
+}
+
+// transition 需在持有锁时调用
+func (cb *CircuitBreaker) transition(to breakerState) (breakerState, bool) {
+    from := cb.state
+    if from == to {
+        return from, false
+    }
+    cb.state = to
+    cb.lastChange = time.Now()
+    if to != breakerHalfOpen {
+        cb.halfOpenInFlight = 0
+    }
+    return from, true
+}
+
+func (cb *CircuitBreaker) fireHook(from, to breakerState, lastError string) {
+    logEvent("CircuitBreakerStateChange", map[string]interface{}{
+        "endpoint":  cb.endpoint,
+        "from":      from.String(),
+        "to":        to.String(),
+        "lastError": lastError,
+    })
+    if breakerStateHook != nil {
+        go breakerStateHook(cb.endpoint, from, to, lastError)
+    }
+}
+
+func (cb *CircuitBreaker) Snapshot() breakerSnapshot {
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+    s := breakerSnapshot{
+        Endpoint:   cb.endpoint,
+        State:      cb.state,
+        Failures:   cb.failures,
+        LastError:  cb.lastError,
+        LastChange: cb.lastChange,
+    }
+    if cb.state == breakerOpen {
+        if remaining := breakerOpenDuration() - time.Since(cb.openedAt); remaining > 0 {
+            s.RetryAfter = remaining
+        }
+    }
+    return s
+}
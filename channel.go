@@ -0,0 +1,320 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf16"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ChannelConfig 是单个频道的自动处理配置，机器人需要是频道管理员
+type ChannelConfig struct {
+    Channel        string `yaml:"channel"`
+    Mode           string `yaml:"mode"`
+    Model          string `yaml:"model"`
+    Prompt         string `yaml:"prompt"`
+    TargetLanguage string `yaml:"target_language"`
+    MinLength      int    `yaml:"min_length"`
+}
+
+const (
+    channelModeSummary    = "summary"
+    channelModeTranslate  = "translate"
+    channelModeDiscussion = "discussion"
+    channelModeCaption    = "caption"
+)
+
+const (
+    maxMessageLength = 4096
+    maxCaptionLength = 1024
+)
+
+var channelModeLabels = map[string]string{
+    channelModeSummary:   "📝 摘要",
+    channelModeTranslate: "🌐 译文",
+}
+
+// channelCaptionDraft 是等待管理员确认的配文草稿
+type channelCaptionDraft struct {
+    ChatID    int64
+    MessageID int
+    Caption   string
+}
+
+var (
+    captionDraftsMu sync.Mutex
+    captionDrafts   = map[string]channelCaptionDraft{}
+)
+
+// channelConfigFor 按用户名（可带 @）或 ID 查找频道配置
+func channelConfigFor(chat *tgbotapi.Chat) (ChannelConfig, bool) {
+    if chat == nil {
+        return ChannelConfig{}, false
+    }
+    for _, c := range config.Channels {
+        name := strings.TrimPrefix(c.Channel, "@")
+        if (chat.UserName != "" && strings.EqualFold(name, chat.UserName)) || name == chatKey(chat.ID) {
+            return c, true
+        }
+    }
+    return ChannelConfig{}, false
+}
+
+// postText 返回帖子的文字，图片等媒体帖子返回说明文字
+func postText(post *tgbotapi.Message) string {
+    if post.Text != "" {
+        return post.Text
+    }
+    return post.Caption
+}
+
+func postHasMedia(post *tgbotapi.Message) bool {
+    return len(post.Photo) > 0 || post.Video != nil || post.Animation != nil || post.Document != nil || post.Audio != nil || post.Voice != nil
+}
+
+// handleChannelPost 按频道配置处理新帖子：追加摘要或译文，或给管理员发送配文草稿
+func handleChannelPost(bot *tgbotapi.BotAPI, post *tgbotapi.Message) {
+    cfg, ok := channelConfigFor(post.Chat)
+    if !ok || !isAllowed(post.Chat.ID, post.Chat.UserName) {
+        return
+    }
+    text := postText(post)
+    if len([]rune(text)) < cfg.MinLength {
+        return
+    }
+    logEvent("ReceivedChannelPost", map[string]interface{}{
+        "channel": post.Chat.UserName,
+        "mode":    cfg.Mode,
+        "text":    text,
+    })
+
+    switch cfg.Mode {
+    case channelModeSummary, channelModeTranslate:
+        if text == "" {
+            return
+        }
+        content, err := channelCompletion(cfg, post.Chat, text)
+        if err != nil {
+            logEvent("ChannelCompletionError", err.Error())
+            return
+        }
+        appendToPost(bot, post, channelModeLabels[cfg.Mode], content)
+    case channelModeCaption:
+        if !postHasMedia(post) {
+            return
+        }
+        // 模型暂时看不到媒体内容，根据已有说明或文件名起草
+        source := text
+        if source == "" && post.Document != nil {
+            source = "文件名：" + post.Document.FileName
+        }
+        if source == "" {
+            return
+        }
+        content, err := channelCompletion(cfg, post.Chat, source)
+        if err != nil {
+            logEvent("ChannelCompletionError", err.Error())
+            return
+        }
+        sendCaptionDraft(bot, post, content)
+    case channelModeDiscussion:
+        // 在讨论群收到自动转发的帖子时处理，见 handleDiscussionForward
+    default:
+        logEvent("UnknownChannelMode", map[string]interface{}{
+            "channel": cfg.Channel,
+            "mode":    cfg.Mode,
+        })
+    }
+}
+
+// isDiscussionForward 判断群消息是否是频道帖子自动转发到关联讨论群的副本
+func isDiscussionForward(message *tgbotapi.Message) bool {
+    if !message.IsAutomaticForward || message.SenderChat == nil {
+        return false
+    }
+    cfg, ok := channelConfigFor(message.SenderChat)
+    return ok && cfg.Mode == channelModeDiscussion
+}
+
+// handleDiscussionForward 在讨论群中回复自动转发的帖子，回复会显示在帖子的评论区
+func handleDiscussionForward(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    cfg, _ := channelConfigFor(message.SenderChat)
+    if !isAllowed(message.SenderChat.ID, message.SenderChat.UserName) {
+        return
+    }
+    text := postText(message)
+    if text == "" || len([]rune(text)) < cfg.MinLength {
+        return
+    }
+    content, err := channelCompletion(cfg, message.SenderChat, text)
+    if err != nil {
+        logEvent("ChannelCompletionError", err.Error())
+        return
+    }
+    msg := tgbotapi.NewMessage(message.Chat.ID, mdToTgmd(content))
+    msg.ParseMode = "MarkdownV2"
+    msg.ReplyToMessageID = message.MessageID
+    if _, err := sendThreaded(bot, msg, messageThreadID(message)); err != nil {
+        logEvent("SendDiscussionReplyError", err)
+        plain := tgbotapi.NewMessage(message.Chat.ID, content)
+        plain.ReplyToMessageID = message.MessageID
+        if _, err := sendThreaded(bot, plain, messageThreadID(message)); err != nil {
+            logEvent("SendDiscussionReplyError", err)
+        }
+    }
+}
+
+func channelPrompt(cfg ChannelConfig) string {
+    if cfg.Prompt != "" {
+        return cfg.Prompt
+    }
+    switch cfg.Mode {
+    case channelModeSummary:
+        return "用一两句话概括用户发送的频道帖子，直接输出摘要，不要加前缀。"
+    case channelModeTranslate:
+        lang := cfg.TargetLanguage
+        if lang == "" {
+            lang = "英文"
+        }
+        return fmt.Sprintf("把用户发送的频道帖子翻译成%s，保留原有的段落，只输出译文。", lang)
+    case channelModeDiscussion:
+        return "你是频道的评论助手，针对用户发送的频道帖子写一条简短、有见地的评论或补充说明。"
+    case channelModeCaption:
+        return "为用户发送的频道帖子写一段简洁、吸引人的配文，直接输出配文，不超过 200 字。"
+    }
+    return systemPrompt
+}
+
+func channelCompletion(cfg ChannelConfig, chat *tgbotapi.Chat, text string) (string, error) {
+    model := cfg.Model
+    if model == "" {
+        model = currentModel
+    }
+    // 频道帖子没有具体的发送者，按默认角色限制模型
+    role := defaultRole()
+    resolved, ok := role.resolveModel(model)
+    if !ok {
+        return "", fmt.Errorf("默认角色 %s 不能使用模型 %s", role.label(), model)
+    }
+    model = resolved
+    vars := userPromptVars(chatLocation(chat.ID), nil)
+    vars.ChatTitle = chat.Title
+    vars.Model = model
+    now := time.Now()
+    messages := []Message{
+        {Role: "system", Content: renderSystemPrompt(channelPrompt(cfg), vars), Time: now},
+        {Role: "user", Content: text, Time: now},
+    }
+    result, err := callOpenAIWithRetry(completionRequest{ChatID: chat.ID, Model: model, Messages: messages, NoTools: true, Role: role})
+    if err != nil {
+        return "", err
+    }
+    addUsage(nil, nil, result)
+    return strings.TrimSpace(result.Content), nil
+}
+
+// appendToPost 把内容追加到帖子末尾，保留原有格式；超出长度或编辑失败时改为回复帖子
+func appendToPost(bot *tgbotapi.BotAPI, post *tgbotapi.Message, label, content string) {
+    chatID := post.Chat.ID
+    suffix := "\n\n" + label + "\n" + content
+    var edit tgbotapi.Chattable
+    if post.Text != "" {
+        if len(utf16.Encode([]rune(post.Text+suffix))) <= maxMessageLength {
+            e := tgbotapi.NewEditMessageText(chatID, post.MessageID, post.Text+suffix)
+            e.Entities = post.Entities
+            edit = e
+        }
+    } else if len(utf16.Encode([]rune(post.Caption+suffix))) <= maxCaptionLength {
+        e := tgbotapi.NewEditMessageCaption(chatID, post.MessageID, post.Caption+suffix)
+        e.CaptionEntities = post.CaptionEntities
+        edit = e
+    }
+    if edit != nil {
+        _, err := bot.Send(edit)
+        if err == nil {
+            return
+        }
+        logEvent("EditChannelPostError", err)
+    }
+
+    msg := tgbotapi.NewMessage(chatID, label+"\n"+content)
+    msg.ReplyToMessageID = post.MessageID
+    if _, err := bot.Send(msg); err != nil {
+        logEvent("SendChannelReplyError", err)
+    }
+}
+
+// sendCaptionDraft 把配文草稿发给管理员，确认后才修改帖子
+func sendCaptionDraft(bot *tgbotapi.BotAPI, post *tgbotapi.Message, caption string) {
+    caption = truncateRunes(caption, maxCaptionLength-1)
+    token := hashToken(fmt.Sprintf("%d:%d", post.Chat.ID, post.MessageID))
+    captionDraftsMu.Lock()
+    captionDrafts[token] = channelCaptionDraft{ChatID: post.Chat.ID, MessageID: post.MessageID, Caption: caption}
+    captionDraftsMu.Unlock()
+
+    title := post.Chat.Title
+    if post.Chat.UserName != "" {
+        title += " (@" + post.Chat.UserName + ")"
+    }
+    text := fmt.Sprintf("✍️ 频道 %s 的帖子 #%d 配文草稿：\n\n%s", title, post.MessageID, caption)
+    markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+        tgbotapi.NewInlineKeyboardButtonData("✅ 应用", "chcap:y:"+token),
+        tgbotapi.NewInlineKeyboardButtonData("❌ 忽略", "chcap:n:"+token),
+    ))
+    for _, userID := range adminIDs() {
+        msg := tgbotapi.NewMessage(userID, text)
+        msg.ReplyMarkup = markup
+        if _, err := bot.Send(msg); err != nil {
+            logEvent("SendCaptionDraftError", map[string]interface{}{
+                "userID": userID,
+                "error":  err.Error(),
+            })
+        }
+    }
+}
+
+// handleCaptionDraftCallback 处理配文草稿的应用和忽略按钮，回调数据格式为 "chcap:<y|n>:<令牌>"
+func handleCaptionDraftCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    parts := strings.SplitN(query.Data, ":", 3)
+    if len(parts) != 3 {
+        return
+    }
+    captionDraftsMu.Lock()
+    draft, ok := captionDrafts[parts[2]]
+    delete(captionDrafts, parts[2])
+    captionDraftsMu.Unlock()
+
+    var answer, status string
+    switch {
+    case !ok:
+        answer = "草稿已失效"
+    case parts[1] == "y":
+        edit := tgbotapi.NewEditMessageCaption(draft.ChatID, draft.MessageID, draft.Caption)
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("ApplyCaptionDraftError", err)
+            answer = "应用失败：" + err.Error()
+            // 失败时保留草稿以便重试
+            captionDraftsMu.Lock()
+            captionDrafts[parts[2]] = draft
+            captionDraftsMu.Unlock()
+        } else {
+            answer, status = "配文已应用", "✅ 已应用"
+        }
+    default:
+        answer, status = "已忽略", "❌ 已忽略"
+    }
+
+    if status != "" {
+        text := query.Message.Text + "\n\n" + status + "（" + query.From.FirstName + "）"
+        edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("EditCaptionDraftError", err)
+        }
+    }
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type CompareConfig struct {
+    MaxModels int `yaml:"max_models"`
+}
+
+// comparePicker 是等待用户勾选模型的 /compare 请求，以选择消息的 ID 标识
+type comparePicker struct {
+    message   *tgbotapi.Message
+    prompt    string
+    models    []OpenAIModel
+    selected  map[int]bool
+    page      int
+    createdAt time.Time
+}
+
+// 未操作的多选列表保留的时间
+const comparePickerTTL = time.Hour
+
+// compareRun 是一次已发出的对比，保存各模型的回答以便采用
+type compareRun struct {
+    key       string
+    sessionID string
+    prompt    string
+    userID    int64 // 发起对比的用户，只有该用户可以采用回答
+    userMsgID int
+    models    []string
+    answers   []string
+    msgIDs    []int
+    createdAt time.Time
+}
+
+var (
+    compareMu      sync.Mutex
+    comparePickers = map[string]*comparePicker{}
+    compareRuns    = map[int64]*compareRun{}
+    nextCompareID  int64
+)
+
+func compareMaxModels() int {
+    if config.Compare.MaxModels > 0 {
+        return config.Compare.MaxModels
+    }
+    return 4
+}
+
+func comparePickerKey(chatID int64, messageID int) string {
+    return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+// handleCompareCommand 处理 /compare：指定了模型列表时直接对比，否则显示多选列表
+func handleCompareCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    chatID := message.Chat.ID
+    args := strings.TrimSpace(message.CommandArguments())
+    if args == "" {
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "用法：/compare 模型1,模型2 <问题>\n或 /compare <问题> 后在列表中勾选模型"), messageThreadID(message))
+        return
+    }
+
+    fields := strings.Fields(args)
+    if strings.Contains(fields[0], ",") {
+        var models []string
+        for _, m := range strings.Split(fields[0], ",") {
+            if m = strings.TrimSpace(m); m != "" {
+                models = append(models, m)
+            }
+        }
+        prompt := strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+        if prompt == "" {
+            sendThreaded(bot, tgbotapi.NewMessage(chatID, "请在模型列表后输入要对比的问题"), messageThreadID(message))
+            return
+        }
+        if len(models) > compareMaxModels() {
+            sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("最多同时对比 %d 个模型", compareMaxModels())), messageThreadID(message))
+            return
+        }
+        role := messageRole(message)
+        for _, m := range models {
+            if !role.allowsModel(m) {
+                sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("你的角色（%s）不能使用 %s", role.label(), m)), messageThreadID(message))
+                return
+            }
+        }
+        go runCompare(bot, message, prompt, models)
+        return
+    }
+
+    models := messageRole(message).filterModels(getOpenAIModels())
+    if len(models) == 0 {
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "暂时无法获取模型列表，请使用 /compare 模型1,模型2 <问题>"), messageThreadID(message))
+        return
+    }
+    picker := &comparePicker{
+        message:   message,
+        prompt:    args,
+        models:    append([]OpenAIModel(nil), models...),
+        selected:  map[int]bool{},
+        createdAt: time.Now(),
+    }
+    msg := tgbotapi.NewMessage(chatID, comparePickerText(picker))
+    msg.ReplyMarkup = comparePickerKeyboard(picker)
+    sent, err := sendThreaded(bot, msg, messageThreadID(message))
+    if err != nil {
+        logEvent("SendComparePickerError", err)
+        return
+    }
+    compareMu.Lock()
+    for k, p := range comparePickers {
+        if time.Since(p.createdAt) > comparePickerTTL {
+            delete(comparePickers, k)
+        }
+    }
+    comparePickers[comparePickerKey(chatID, sent.MessageID)] = picker
+    compareMu.Unlock()
+}
+
+func comparePickerText(picker *comparePicker) string {
+    text := fmt.Sprintf("🆚 请选择要对比的模型（最多 %d 个，已选 %d 个）：\n\n%s", compareMaxModels(), len(picker.selected), truncateRunes(picker.prompt, 200))
+    // 分页后其他页勾选的模型看不到，在正文中列出
+    var selected []string
+    for i, model := range picker.models {
+        if picker.selected[i] {
+            selected = append(selected, model.ID)
+        }
+    }
+    if len(selected) > 0 {
+        text += "\n\n已选：" + strings.Join(selected, "、")
+    }
+    return text
+}
+
+// comparePickerKeyboard 按模型列表的分页大小显示当前页的模型
+func comparePickerKeyboard(picker *comparePicker) tgbotapi.InlineKeyboardMarkup {
+    size := pickerPageSize()
+    pages := (len(picker.models) + size - 1) / size
+    if picker.page >= pages {
+        picker.page = pages - 1
+    }
+    if picker.page < 0 {
+        picker.page = 0
+    }
+    start := picker.page * size
+    end := start + size
+    if end > len(picker.models) {
+        end = len(picker.models)
+    }
+    keyboard := modelKeyboard(picker.models[start:end], func(i int, model OpenAIModel) tgbotapi.InlineKeyboardButton {
+        label := model.ID
+        if picker.selected[start+i] {
+            label = "✅ " + label
+        }
+        return tgbotapi.NewInlineKeyboardButtonData(label, "cmp:t:"+strconv.Itoa(start+i))
+    })
+    var nav []tgbotapi.InlineKeyboardButton
+    if picker.page > 0 {
+        nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀️ 上一页", "cmp:p:"+strconv.Itoa(picker.page-1)))
+    }
+    if pages > 1 {
+        nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", picker.page+1, pages), "cmp:done"))
+    }
+    if picker.page < pages-1 {
+        nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("下一页 ▶️", "cmp:p:"+strconv.Itoa(picker.page+1)))
+    }
+    if len(nav) > 0 {
+        keyboard = append(keyboard, nav)
+    }
+    keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+        tgbotapi.NewInlineKeyboardButtonData("🚀 开始对比", "cmp:go"),
+        tgbotapi.NewInlineKeyboardButtonData("取消", "cmp:x"),
+    ))
+    return tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+}
+
+// handleCompareCallback 处理多选列表（cmp:t、cmp:p、cmp:go、cmp:x）和采用回答（cmp:a:<对比ID>:<序号>）的按钮
+func handleCompareCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    parts := strings.Split(strings.TrimPrefix(query.Data, "cmp:"), ":")
+    if parts[0] == "done" {
+        bot.Request(tgbotapi.NewCallback(query.ID, ""))
+        return
+    }
+    if parts[0] == "a" {
+        answer := "无效操作"
+        if len(parts) == 3 {
+            runID, _ := strconv.ParseInt(parts[1], 10, 64)
+            index, _ := strconv.Atoi(parts[2])
+            answer = adoptCompareAnswer(bot, query.Message.Chat.ID, query.From.ID, runID, index)
+        }
+        bot.Request(tgbotapi.NewCallback(query.ID, answer))
+        return
+    }
+
+    chatID := query.Message.Chat.ID
+    pickerKey := comparePickerKey(chatID, query.Message.MessageID)
+    compareMu.Lock()
+    picker, ok := comparePickers[pickerKey]
+    if !ok {
+        compareMu.Unlock()
+        bot.Request(tgbotapi.NewCallback(query.ID, "该对比已失效，请重新发送 /compare"))
+        return
+    }
+    // 开始对比会消耗发起者的额度，只允许发起者操作
+    if picker.message.From == nil || picker.message.From.ID != query.From.ID {
+        compareMu.Unlock()
+        bot.Request(tgbotapi.NewCallback(query.ID, "只有发起对比的用户可以操作"))
+        return
+    }
+
+    answer := ""
+    switch parts[0] {
+    case "p":
+        picker.page, _ = strconv.Atoi(parts[len(parts)-1])
+        edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, comparePickerText(picker), comparePickerKeyboard(picker))
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("EditComparePickerError", err)
+        }
+    case "t":
+        index, err := strconv.Atoi(parts[len(parts)-1])
+        if err != nil || index < 0 || index >= len(picker.models) {
+            break
+        }
+        if picker.selected[index] {
+            delete(picker.selected, index)
+        } else if len(picker.selected) >= compareMaxModels() {
+            answer = fmt.Sprintf("最多选择 %d 个模型", compareMaxModels())
+            break
+        } else {
+            picker.selected[index] = true
+        }
+        edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, comparePickerText(picker), comparePickerKeyboard(picker))
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("EditComparePickerError", err)
+        }
+    case "go":
+        if len(picker.selected) < 2 {
+            answer = "请至少选择 2 个模型"
+            break
+        }
+        var models []string
+        for i, model := range picker.models {
+            if picker.selected[i] {
+                models = append(models, model.ID)
+            }
+        }
+        delete(comparePickers, pickerKey)
+        bot.Request(tgbotapi.NewDeleteMessage(chatID, query.Message.MessageID))
+        go runCompare(bot, picker.message, picker.prompt, models)
+    case "x":
+        delete(comparePickers, pickerKey)
+        bot.Request(tgbotapi.NewDeleteMessage(chatID, query.Message.MessageID))
+    }
+    compareMu.Unlock()
+
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
+
+// runCompare 把相同的上下文并发发送给多个模型，每个回答单独发送并附带采用按钮
+func runCompare(bot *tgbotapi.BotAPI, message *tgbotapi.Message, prompt string, models []string) {
+    // 一次对比计为一次请求，Token 按各模型的实际用量累计
+    if !consumeQuota(bot, message) {
+        return
+    }
+    chatID := message.Chat.ID
+    key := sessionKeyFor(message)
+    role := messageRole(message)
+    vars := newPromptVars(message)
+    now := time.Now()
+
+    var sessionID string
+    var params GenerationParams
+    var context []Message
+    withActiveSession(key, func(sess *Session) {
+        sess.pruneExpired(now)
+        sessionID = sess.ID
+        params = sess.Params
+        context = append(sess.requestMessages(vars), Message{Role: "user", Content: prompt, Time: now})
+    })
+
+    var userID int64
+    if message.From != nil {
+        userID = message.From.ID
+    }
+    run := &compareRun{
+        key:       key,
+        sessionID: sessionID,
+        prompt:    prompt,
+        userID:    userID,
+        userMsgID: message.MessageID,
+        models:    models,
+        answers:   make([]string, len(models)),
+        msgIDs:    make([]int, len(models)),
+        createdAt: now,
+    }
+    compareMu.Lock()
+    nextCompareID++
+    runID := nextCompareID
+    compareRuns[runID] = run
+    // 超过记忆保留时间的对比不再允许采用
+    for id, r := range compareRuns {
+        if time.Since(r.createdAt) > time.Duration(config.HistoryTimeoutMinutes)*time.Minute {
+            delete(compareRuns, id)
+        }
+    }
+    compareMu.Unlock()
+
+    logEvent("CompareStarted", map[string]interface{}{
+        "chatID": chatID,
+        "models": models,
+    })
+    sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("🆚 正在对比 %d 个模型：%s", len(models), strings.Join(models, "、"))), messageThreadID(message))
+
+    showReasoning := reasoningMode(chatID) == reasoningShow
+    var wg sync.WaitGroup
+    for i, model := range models {
+        wg.Add(1)
+        go func(i int, model string) {
+            defer wg.Done()
+            start := time.Now()
+            result, err := callOpenAIWithRetry(completionRequest{ChatID: chatID, Model: model, Params: params, Messages: context, NoTools: !role.allowsCommand("tools"), Role: role})
+            duration := time.Since(start)
+
+            header := fmt.Sprintf("🆚 [%d/%d] %s", i+1, len(models), model)
+            var text, plain string
+            var keyboard *tgbotapi.InlineKeyboardMarkup
+            if err != nil {
+                plain = fmt.Sprintf("%s\n\n抱歉，发生了错误：%v", header, err)
+                text = escapeMarkdownV2(plain)
+            } else {
+                recordUsage(bot, message, result)
+                compareMu.Lock()
+                run.answers[i] = result.Content
+                compareMu.Unlock()
+
+                stats := fmt.Sprintf("⏱ %.2f秒    📊 输入: %d    📈 输出: %d", duration.Seconds(), result.InputTokens, result.OutputTokens)
+                if result.ReasoningTokens > 0 {
+                    stats += fmt.Sprintf("    💭 推理: %d", result.ReasoningTokens)
+                }
+                body := formatToolNotes(result.ToolNotes) + mdToTgmd(result.Content)
+                if showReasoning {
+                    if withReasoning := formatReasoning(result.Reasoning) + body; !messageTooLong(withReasoning) {
+                        body = withReasoning
+                    } else if result.Reasoning != "" {
+                        sendReasoningFile(bot, chatID, messageThreadID(message), message.MessageID, result.Reasoning)
+                    }
+                }
+                text = escapeMarkdownV2(header) + "\n\n" + body + "\n\n" + escapeMarkdownV2(stats)
+                plain = header + "\n\n" + result.Content + "\n\n" + stats
+                markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+                    tgbotapi.NewInlineKeyboardButtonData("✅ 采用此回答", fmt.Sprintf("cmp:a:%d:%d", runID, i)),
+                ))
+                keyboard = &markup
+            }
+
+            msg := tgbotapi.NewMessage(chatID, text)
+            msg.ParseMode = "MarkdownV2"
+            msg.ReplyToMessageID = message.MessageID
+            if keyboard != nil {
+                msg.ReplyMarkup = *keyboard
+            }
+            sent, sendErr := sendThreaded(bot, msg, messageThreadID(message))
+            if sendErr != nil {
+                logEvent("SendCompareAnswerError", sendErr)
+                plainMsg := tgbotapi.NewMessage(chatID, plain)
+                plainMsg.ReplyToMessageID = message.MessageID
+                if keyboard != nil {
+                    plainMsg.ReplyMarkup = *keyboard
+                }
+                sent, sendErr = sendThreaded(bot, plainMsg, messageThreadID(message))
+            }
+            if sendErr == nil {
+                compareMu.Lock()
+                run.msgIDs[i] = sent.MessageID
+                compareMu.Unlock()
+            }
+        }(i, model)
+    }
+    wg.Wait()
+    logEvent("CompareFinished", map[string]interface{}{
+        "chatID": chatID,
+        "models": models,
+    })
+}
+
+// adoptCompareAnswer 把选中的回答连同问题写入对比时所在的会话，并移除所有采用按钮
+func adoptCompareAnswer(bot *tgbotapi.BotAPI, chatID int64, userID int64, runID int64, index int) string {
+    compareMu.Lock()
+    run, ok := compareRuns[runID]
+    if ok && run.userID != userID {
+        compareMu.Unlock()
+        return "只有发起对比的用户可以采用回答"
+    }
+    valid := ok && index >= 0 && index < len(run.models) && run.answers[index] != ""
+    var answer string
+    var msgIDs []int
+    if valid {
+        delete(compareRuns, runID)
+        answer = run.answers[index]
+        msgIDs = append([]int(nil), run.msgIDs...)
+    }
+    compareMu.Unlock()
+    if !ok {
+        return "该对比已失效或已采用过回答"
+    }
+    if !valid {
+        return "该回答不可用"
+    }
+
+    now := time.Now()
+    adopted := withSession(run.key, run.sessionID, func(sess *Session) {
+        sess.pruneExpired(now)
+        if sess.RemainingRounds > 0 {
+            sess.RemainingRounds--
+        } else {
+            sess.reset(now)
+        }
+        sess.History = append(sess.History,
+            Message{Role: "user", Content: run.prompt, Time: now, TgMsgID: run.userMsgID},
+            Message{Role: "assistant", Content: answer, Time: now, TgMsgID: msgIDs[index]},
+        )
+        sess.InteractionTime = now
+        sess.UpdatedAt = now
+    })
+    if !adopted {
+        return "原会话已被删除"
+    }
+
+    for i, msgID := range msgIDs {
+        if msgID == 0 {
+            continue
+        }
+        edit := tgbotapi.NewEditMessageReplyMarkup(chatID, msgID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+        if i == index {
+            edit.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+                tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✅ 已采用", "cmp:done")),
+            }}
+        }
+        if _, err := bot.Request(edit); err != nil {
+            logEvent("EditCompareMarkupError", err)
+        }
+    }
+    logEvent("CompareAnswerAdopted", map[string]interface{}{
+        "chatID": chatID,
+        "model":  run.models[index],
+    })
+    return "已采用 " + run.models[index] + " 的回答"
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type editKind int
+
+const (
+    editUnknown editKind = iota
+    editLastTurn
+    editOlderTurn
+)
+
+// pendingEdit 是等待用户确认分支的旧消息编辑
+type pendingEdit struct {
+    key     string
+    message *tgbotapi.Message
+    at      time.Time
+}
+
+// 分支按钮的有效期
+const pendingEditTTL = time.Hour
+
+var (
+    pendingEditsMu sync.Mutex
+    pendingEdits   = map[string]pendingEdit{}
+)
+
+// editedMessageAccepted 对编辑后的消息执行与新消息相同的权限和群聊触发检查，群聊中会去掉 @提及
+func editedMessageAccepted(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+    if message.Text == "" || message.IsCommand() {
+        return false
+    }
+    if isGroupChat(message.Chat) {
+        if !isGroupAllowed(message.Chat) {
+            return false
+        }
+        text, triggered := groupTriggered(bot, message)
+        if !triggered {
+            return false
+        }
+        message.Text = text
+        return true
+    }
+    return isAllowed(message.Chat.ID, message.Chat.UserName)
+}
+
+// handleEditedMessage 处理编辑过的消息：编辑的是当前会话最后一轮提问时替换这一轮并重新回答，
+// 原回答原地更新；编辑的是更早的提问时询问是否从该处分支
+func handleEditedMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    key := sessionKeyFor(message)
+    kind := editedTurnKind(key, message.MessageID)
+    logEvent("ReceivedEditedMessage", map[string]interface{}{
+        "key":       key,
+        "messageID": message.MessageID,
+        "kind":      kind,
+    })
+    switch kind {
+    case editLastTurn:
+        // 先检查角色和额度，未通过时保留原来的这一轮
+        role, ok := admitMessage(bot, message)
+        if !ok {
+            return
+        }
+        answerMsgID, kind := rewindToTurn(key, message.MessageID)
+        if kind != editLastTurn {
+            return
+        }
+        respondToMessage(bot, message, answerMsgID, role)
+    case editOlderTurn:
+        offerEditBranch(bot, message, key)
+    }
+}
+
+// locateEditedTurnLocked 在当前会话中查找被编辑的提问，返回其位置、原回答的消息 ID 和编辑类型，调用方需持有 stateMu
+func locateEditedTurnLocked(key string, tgMsgID int) (int, int, editKind) {
+    active := activeSessionLocked(key)
+    index := active.findTurn(tgMsgID)
+    if index < 0 || active.History[index].Role != "user" {
+        for _, sess := range chatSessionsLocked(key).Sessions {
+            if i := sess.findTurn(tgMsgID); i >= 0 && sess.History[i].Role == "user" {
+                return -1, 0, editOlderTurn
+            }
+        }
+        return -1, 0, editUnknown
+    }
+
+    answerMsgID := 0
+    for _, m := range active.History[index+1:] {
+        if m.Role == "user" {
+            return -1, 0, editOlderTurn
+        }
+        if m.Role == "assistant" && m.TgMsgID != 0 {
+            answerMsgID = m.TgMsgID
+        }
+    }
+    return index, answerMsgID, editLastTurn
+}
+
+// editedTurnKind 判断被编辑的提问属于哪种情况，不修改会话
+func editedTurnKind(key string, tgMsgID int) editKind {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    _, _, kind := locateEditedTurnLocked(key, tgMsgID)
+    return kind
+}
+
+// rewindToTurn 查找被编辑的提问；是当前会话的最后一轮时删除这一轮及其回答，并返回原回答的消息 ID
+func rewindToTurn(key string, tgMsgID int) (int, editKind) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+
+    index, answerMsgID, kind := locateEditedTurnLocked(key, tgMsgID)
+    if kind != editLastTurn {
+        return 0, kind
+    }
+    active := activeSessionLocked(key)
+    active.History = active.History[:index]
+    // 重新回答不额外消耗轮数
+    if active.RemainingRounds < config.HistoryLength {
+        active.RemainingRounds++
+    }
+    active.UpdatedAt = time.Now()
+    saveStateLocked()
+    return answerMsgID, editLastTurn
+}
+
+func offerEditBranch(bot *tgbotapi.BotAPI, message *tgbotapi.Message, key string) {
+    token := hashToken(fmt.Sprintf("%d:%d:%d", message.Chat.ID, message.MessageID, message.EditDate))
+    pendingEditsMu.Lock()
+    for t, p := range pendingEdits {
+        if time.Since(p.at) > pendingEditTTL {
+            delete(pendingEdits, t)
+        }
+    }
+    pendingEdits[token] = pendingEdit{key: key, message: message, at: time.Now()}
+    pendingEditsMu.Unlock()
+
+    msg := tgbotapi.NewMessage(message.Chat.ID, "✏️ 你编辑了一条较早的消息，之后的对话不会自动改变。\n要从这条消息处分支出新会话，并用修改后的内容重新提问吗？")
+    msg.ReplyToMessageID = message.MessageID
+    msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+        tgbotapi.NewInlineKeyboardButtonData("🌿 从此处分支", "edit:"+token),
+    ))
+    if _, err := sendThreaded(bot, msg, messageThreadID(message)); err != nil {
+        logEvent("SendEditBranchOfferError", err)
+    }
+}
+
+// handleEditBranchCallback 处理分支按钮：复制被编辑提问之前的上下文创建新会话，再用修改后的内容提问
+func handleEditBranchCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    token := strings.TrimPrefix(query.Data, "edit:")
+    pendingEditsMu.Lock()
+    pending, ok := pendingEdits[token]
+    if ok && pending.message.From != nil && pending.message.From.ID == query.From.ID {
+        delete(pendingEdits, token)
+    }
+    pendingEditsMu.Unlock()
+
+    if !ok || time.Since(pending.at) > pendingEditTTL {
+        bot.Request(tgbotapi.NewCallback(query.ID, "操作已过期"))
+        return
+    }
+    if pending.message.From == nil || pending.message.From.ID != query.From.ID {
+        bot.Request(tgbotapi.NewCallback(query.ID, "只有编辑消息的用户可以分支"))
+        return
+    }
+
+    role, ok := admitMessage(bot, pending.message)
+    if !ok {
+        // 未通过检查时保留按钮，之后可以再试
+        pendingEditsMu.Lock()
+        pendingEdits[token] = pending
+        pendingEditsMu.Unlock()
+        bot.Request(tgbotapi.NewCallback(query.ID, ""))
+        return
+    }
+    note := branchBeforeTurn(pending.key, pending.message.MessageID)
+    if note == "" {
+        note = "原消息已不在会话中，将在当前会话中重新提问"
+    }
+    edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, note)
+    if _, err := bot.Send(edit); err != nil {
+        logEvent("EditBranchOfferError", err)
+    }
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, "已分支")); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+    go respondToMessage(bot, pending.message, 0, role)
+}
+
+// branchBeforeTurn 复制被编辑提问之前的上下文创建分支会话并切换过去，找不到该提问时返回空字符串
+func branchBeforeTurn(key string, tgMsgID int) string {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+
+    chat := chatSessionsLocked(key)
+    var source *Session
+    index := -1
+    for i := len(chat.Sessions) - 1; i >= 0; i-- {
+        if idx := chat.Sessions[i].findTurn(tgMsgID); idx >= 0 && chat.Sessions[i].History[idx].Role == "user" {
+            source, index = chat.Sessions[i], idx
+            break
+        }
+    }
+    if source == nil {
+        return ""
+    }
+
+    now := time.Now()
+    history := make([]Message, index)
+    copy(history, source.History[:index])
+    for i := range history {
+        history[i].Time = now
+    }
+    branch := chat.newSessionLocked(source.Model, source.SystemPrompt, source.Params)
+    branch.Persona = source.Persona
+    branch.ParentID = source.ID
+    branch.History = history
+    branch.Title = truncateRunes("✏️ "+source.displayTitle(), titleMaxRunes)
+    saveStateLocked()
+
+    logEvent("SessionBranchedOnEdit", map[string]interface{}{
+        "key":    key,
+        "from":   source.ID,
+        "to":     branch.ID,
+        "atTurn": index,
+    })
+    return fmt.Sprintf("🌿 已从会话 #%s 的这条消息处分支为新会话 #%s，正在重新回答…", source.ID, branch.ID)
+}
+
+// editAnswer 原地更新旧回答，MarkdownV2 失败时改用纯文本；内容没有变化也视为成功
+func editAnswer(bot *tgbotapi.BotAPI, chatID int64, messageID int, formatted, plain string) bool {
+    edit := tgbotapi.NewEditMessageText(chatID, messageID, formatted)
+    edit.ParseMode = "MarkdownV2"
+    _, err := bot.Send(edit)
+    if err == nil || strings.Contains(err.Error(), "message is not modified") {
+        return true
+    }
+    logEvent("EditAnswerError", err)
+    if plain == "" {
+        return false
+    }
+    _, err = bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, plain))
+    if err == nil || strings.Contains(err.Error(), "message is not modified") {
+        return true
+    }
+    logEvent("EditPlainAnswerError", err)
+    return false
+}
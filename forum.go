@@ -0,0 +1,279 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 使用的 telegram-bot-api 版本不认识话题字段，需要从 getUpdates 的原始 JSON 中取出 message_thread_id
+
+// TopicSettings 是论坛话题独立的设置，覆盖所在聊天的设置
+type TopicSettings struct {
+    SystemPrompt *string `json:"system_prompt,omitempty"`
+    Model        string  `json:"model,omitempty"`
+}
+
+type messageRef struct {
+    ChatID    int64
+    MessageID int
+}
+
+// topicMessage 只解析消息中与话题相关的字段
+type topicMessage struct {
+    MessageID       int  `json:"message_id"`
+    MessageThreadID int  `json:"message_thread_id"`
+    IsTopicMessage  bool `json:"is_topic_message"`
+    Chat            struct {
+        ID int64 `json:"id"`
+    } `json:"chat"`
+    ReplyToMessage *topicMessage `json:"reply_to_message"`
+}
+
+type topicUpdate struct {
+    Message       *topicMessage `json:"message"`
+    EditedMessage *topicMessage `json:"edited_message"`
+    CallbackQuery *struct {
+        Message *topicMessage `json:"message"`
+    } `json:"callback_query"`
+}
+
+// 最多记住的消息数，超出后丢弃最早的记录
+const maxTopicRefs = 10000
+
+var (
+    topicMu    sync.Mutex
+    topicIDs   = map[messageRef]int{}
+    topicOrder []messageRef
+)
+
+func rememberThread(chatID int64, messageID, threadID int) {
+    if threadID == 0 {
+        return
+    }
+    topicMu.Lock()
+    defer topicMu.Unlock()
+    ref := messageRef{chatID, messageID}
+    if _, ok := topicIDs[ref]; !ok {
+        topicOrder = append(topicOrder, ref)
+    }
+    topicIDs[ref] = threadID
+    for len(topicOrder) > maxTopicRefs {
+        delete(topicIDs, topicOrder[0])
+        topicOrder = topicOrder[1:]
+    }
+}
+
+func (m *topicMessage) remember() {
+    if m == nil {
+        return
+    }
+    if m.IsTopicMessage {
+        rememberThread(m.Chat.ID, m.MessageID, m.MessageThreadID)
+    }
+    m.ReplyToMessage.remember()
+}
+
+// messageThreadID 返回消息所在的论坛话题 ID，不在话题中（包括 General 话题）时为 0
+func messageThreadID(message *tgbotapi.Message) int {
+    if message == nil || message.Chat == nil {
+        return 0
+    }
+    topicMu.Lock()
+    defer topicMu.Unlock()
+    return topicIDs[messageRef{message.Chat.ID, message.MessageID}]
+}
+
+// getUpdatesChan 与 bot.GetUpdatesChan 相同，但会记录每条消息所在的话题
+// 库的更新循环直接把 getUpdates 的结果解析为 tgbotapi.Update，拿不到原始 JSON，所以这里需要自己轮询
+func getUpdatesChan(bot *tgbotapi.BotAPI, u tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+    ch := make(chan tgbotapi.Update, bot.Buffer)
+    go func() {
+        for {
+            updates, err := getUpdates(bot, u)
+            if err != nil {
+                log.Println(err)
+                log.Println("Failed to get updates, retrying in 3 seconds...")
+                time.Sleep(time.Second * 3)
+                continue
+            }
+            for _, update := range updates {
+                if update.UpdateID >= u.Offset {
+                    u.Offset = update.UpdateID + 1
+                    ch <- update
+                }
+            }
+        }
+    }()
+    return ch
+}
+
+func getUpdates(bot *tgbotapi.BotAPI, u tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+    params := tgbotapi.Params{}
+    params.AddNonZero("offset", u.Offset)
+    params.AddNonZero("limit", u.Limit)
+    params.AddNonZero("timeout", u.Timeout)
+    params.AddInterface("allowed_updates", u.AllowedUpdates)
+
+    resp, err := bot.MakeRequest("getUpdates", params)
+    if err != nil {
+        return nil, err
+    }
+    var raws []json.RawMessage
+    if err := json.Unmarshal(resp.Result, &raws); err != nil {
+        return nil, err
+    }
+    updates := make([]tgbotapi.Update, 0, len(raws))
+    for _, raw := range raws {
+        var update tgbotapi.Update
+        if err := json.Unmarshal(raw, &update); err != nil {
+            return nil, err
+        }
+        updates = append(updates, update)
+
+        // 只有话题中的消息才需要再解析话题字段
+        if !bytes.Contains(raw, []byte(`"is_topic_message"`)) {
+            continue
+        }
+        var t topicUpdate
+        if err := json.Unmarshal(raw, &t); err != nil {
+            logEvent("UnmarshalTopicsError", err.Error())
+            continue
+        }
+        t.Message.remember()
+        t.EditedMessage.remember()
+        if t.CallbackQuery != nil {
+            t.CallbackQuery.Message.remember()
+        }
+    }
+    return updates, nil
+}
+
+// sendThreaded 把消息发送到指定话题，threadID 为 0 时等同于 bot.Send
+func sendThreaded(bot *tgbotapi.BotAPI, msg tgbotapi.MessageConfig, threadID int) (tgbotapi.Message, error) {
+    if threadID == 0 {
+        return bot.Send(msg)
+    }
+
+    params := tgbotapi.Params{}
+    params.AddFirstValid("chat_id", msg.ChatID, msg.ChannelUsername)
+    params.AddNonZero("message_thread_id", threadID)
+    params.AddNonEmpty("text", msg.Text)
+    params.AddNonEmpty("parse_mode", msg.ParseMode)
+    params.AddBool("disable_web_page_preview", msg.DisableWebPagePreview)
+    params.AddNonZero("reply_to_message_id", msg.ReplyToMessageID)
+    params.AddBool("disable_notification", msg.DisableNotification)
+    params.AddBool("allow_sending_without_reply", msg.AllowSendingWithoutReply)
+    if err := params.AddInterface("reply_markup", msg.ReplyMarkup); err != nil {
+        return tgbotapi.Message{}, err
+    }
+    if len(msg.Entities) > 0 {
+        if err := params.AddInterface("entities", msg.Entities); err != nil {
+            return tgbotapi.Message{}, err
+        }
+    }
+
+    resp, err := bot.MakeRequest("sendMessage", params)
+    if err != nil {
+        return tgbotapi.Message{}, err
+    }
+    var sent tgbotapi.Message
+    if err := json.Unmarshal(resp.Result, &sent); err != nil {
+        return tgbotapi.Message{}, err
+    }
+    if sent.Chat != nil {
+        rememberThread(sent.Chat.ID, sent.MessageID, threadID)
+    }
+    return sent, nil
+}
+
+// sendDocumentThreaded 把文件发送到指定话题，threadID 为 0 时等同于 bot.Send
+func sendDocumentThreaded(bot *tgbotapi.BotAPI, doc tgbotapi.DocumentConfig, threadID int) error {
+    if threadID == 0 {
+        _, err := bot.Send(doc)
+        return err
+    }
+
+    params := tgbotapi.Params{}
+    params.AddFirstValid("chat_id", doc.ChatID, doc.ChannelUsername)
+    params.AddNonZero("message_thread_id", threadID)
+    params.AddNonEmpty("caption", doc.Caption)
+    params.AddNonEmpty("parse_mode", doc.ParseMode)
+    params.AddNonZero("reply_to_message_id", doc.ReplyToMessageID)
+    params.AddBool("disable_notification", doc.DisableNotification)
+    params.AddBool("allow_sending_without_reply", doc.AllowSendingWithoutReply)
+
+    _, err := bot.UploadFiles("sendDocument", params, []tgbotapi.RequestFile{{Name: "document", Data: doc.File}})
+    return err
+}
+
+// splitSessionKey 从会话键中取出聊天设置的键和话题 ID
+// 会话键格式为 "<聊天ID>[/<话题ID>][:<用户ID>]"
+func splitSessionKey(key string) (string, int) {
+    chat := strings.SplitN(key, ":", 2)[0]
+    parts := strings.SplitN(chat, "/", 2)
+    if len(parts) < 2 {
+        return chat, 0
+    }
+    threadID, _ := strconv.Atoi(parts[1])
+    return parts[0], threadID
+}
+
+// keyThreadID 返回会话键所在的话题 ID
+func keyThreadID(key string) int {
+    _, threadID := splitSessionKey(key)
+    return threadID
+}
+
+// sessionDefaultsLocked 返回新会话的系统提示词、模型和生成参数：话题设置优先于聊天设置，调用方需持有 stateMu
+func sessionDefaultsLocked(key string) (string, string, GenerationParams) {
+    prompt, model := systemPrompt, currentModel
+    var params GenerationParams
+    chatID, threadID := splitSessionKey(key)
+    settings, ok := state.Chats[chatID]
+    if !ok {
+        return prompt, model, params
+    }
+    if settings.SystemPrompt != nil {
+        prompt = *settings.SystemPrompt
+    }
+    if settings.Params != nil {
+        params = *settings.Params
+    }
+    if topic, ok := settings.Topics[strconv.Itoa(threadID)]; ok && threadID != 0 {
+        if topic.SystemPrompt != nil {
+            prompt = *topic.SystemPrompt
+        }
+        if topic.Model != "" {
+            model = topic.Model
+        }
+    }
+    return prompt, model, params
+}
+
+// updateTopicSettings 在锁内修改话题设置并持久化
+func updateTopicSettings(chatID int64, threadID int, fn func(topic *TopicSettings)) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    settings := chatSettingsLocked(chatID)
+    if settings.Topics == nil {
+        settings.Topics = map[string]*TopicSettings{}
+    }
+    id := strconv.Itoa(threadID)
+    topic, ok := settings.Topics[id]
+    if !ok {
+        topic = &TopicSettings{}
+        settings.Topics[id] = topic
+    }
+    fn(topic)
+    if topic.SystemPrompt == nil && topic.Model == "" {
+        delete(settings.Topics, id)
+    }
+    saveStateLocked()
+}
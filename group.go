@@ -0,0 +1,123 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "unicode/utf16"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type GroupsConfig struct {
+    AllowedGroups  []int64  `yaml:"allowed_groups"`
+    TriggerWords   []string `yaml:"trigger_words"`
+    PerUserHistory bool     `yaml:"per_user_history"`
+}
+
+// OpenAI 的 name 字段只允许字母、数字、下划线和连字符
+var messageNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+func isGroupChat(chat *tgbotapi.Chat) bool {
+    return chat != nil && (chat.IsGroup() || chat.IsSuperGroup())
+}
+
+// isGroupAllowed 判断群组是否允许使用；兼容把群组 ID 写在 allowed_users 中的旧配置，也接受 /allow 添加的群组
+func isGroupAllowed(chat *tgbotapi.Chat) bool {
+    if isBanned(chat.ID) {
+        return false
+    }
+    for _, id := range config.Groups.AllowedGroups {
+        if id == chat.ID {
+            return true
+        }
+    }
+    if len(config.Groups.AllowedGroups) == 0 {
+        return isAllowed(chat.ID, chat.UserName)
+    }
+    return accessListed(chat.ID, chat.UserName)
+}
+
+// sessionKey 返回会话存储键：私聊和共享历史的群组按聊天区分，论坛话题各自独立，开启 per_user_history 时群组内按用户区分
+func sessionKey(chat *tgbotapi.Chat, threadID int, user *tgbotapi.User) string {
+    key := chatKey(chat.ID)
+    if threadID != 0 {
+        key += fmt.Sprintf("/%d", threadID)
+    }
+    if isGroupChat(chat) && config.Groups.PerUserHistory && user != nil {
+        key += fmt.Sprintf(":%d", user.ID)
+    }
+    return key
+}
+
+// callbackSessionKey 返回按钮回调对应的会话存储键
+func callbackSessionKey(query *tgbotapi.CallbackQuery) string {
+    return sessionKey(query.Message.Chat, messageThreadID(query.Message), query.From)
+}
+
+// groupTriggered 判断群消息是否在呼叫机器人：@提及、回复机器人或以触发词开头，返回去掉提及后的文本
+func groupTriggered(bot *tgbotapi.BotAPI, message *tgbotapi.Message) (string, bool) {
+    text := message.Text
+    triggered := false
+
+    if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil && message.ReplyToMessage.From.ID == bot.Self.ID {
+        triggered = true
+    }
+
+    mention := "@" + strings.ToLower(bot.Self.UserName)
+    // 实体的偏移量按 UTF-16 编码单元计算
+    units := utf16.Encode([]rune(text))
+    var stripped strings.Builder
+    last := 0
+    for _, entity := range message.Entities {
+        end := entity.Offset + entity.Length
+        if entity.Offset < last || end > len(units) {
+            continue
+        }
+        part := string(utf16.Decode(units[entity.Offset:end]))
+        isMention := entity.Type == "mention" && strings.ToLower(part) == mention
+        isTextMention := entity.Type == "text_mention" && entity.User != nil && entity.User.ID == bot.Self.ID
+        if isMention || isTextMention {
+            triggered = true
+            stripped.WriteString(string(utf16.Decode(units[last:entity.Offset])))
+            last = end
+        }
+    }
+    stripped.WriteString(string(utf16.Decode(units[last:])))
+    text = strings.TrimSpace(stripped.String())
+
+    lower := strings.ToLower(text)
+    for _, word := range config.Groups.TriggerWords {
+        if word != "" && strings.HasPrefix(lower, strings.ToLower(word)) {
+            triggered = true
+            break
+        }
+    }
+    return text, triggered
+}
+
+// commandForOtherBot 判断群命令是否是发给其他机器人的，如 /start@other_bot
+func commandForOtherBot(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+    command := message.CommandWithAt()
+    i := strings.Index(command, "@")
+    return i >= 0 && !strings.EqualFold(command[i+1:], bot.Self.UserName)
+}
+
+// messageName 生成 name 字段，用于让模型区分群里的不同发言人
+func messageName(user *tgbotapi.User) string {
+    if user == nil {
+        return ""
+    }
+    name := user.UserName
+    if name == "" {
+        name = strings.TrimSpace(user.FirstName + "_" + user.LastName)
+    }
+    name = strings.Trim(messageNameRegex.ReplaceAllString(name, "_"), "_")
+    if name == "" {
+        name = fmt.Sprintf("user_%d", user.ID)
+    }
+    if len(name) > 64 {
+        name = name[:64]
+    }
+    return name
+}
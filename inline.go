@@ -0,0 +1,246 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// InlineModeConfig 配置 @机器人 内联查询，需要先在 BotFather 中开启 inline mode
+type InlineModeConfig struct {
+    Enabled        bool   `yaml:"enabled"`
+    Model          string `yaml:"model"`
+    Prompt         string `yaml:"prompt"`
+    DebounceMs     int    `yaml:"debounce_ms"`
+    CacheSeconds   int    `yaml:"cache_seconds"`
+    TimeoutSeconds int    `yaml:"timeout_seconds"`
+}
+
+const (
+    defaultInlinePrompt = "你正在通过 Telegram 内联模式回答问题，回答会直接作为消息发送到聊天中，请简洁准确，不要寒暄。"
+    // 内联结果的消息长度上限为 4096 字符，留出格式化和问题的余量
+    inlineAnswerMaxRunes = 3500
+    // 点击内联提示跳转私聊时 /start 携带的参数
+    inlineStartParameter = "inline"
+)
+
+type inlineAnswer struct {
+    Model   string
+    Content string
+    At      time.Time
+}
+
+var (
+    inlineMu      sync.Mutex
+    inlineLatest  = map[int64]string{} // 用户 ID -> 最新的内联查询 ID
+    inlineCache   = map[string]inlineAnswer{}
+    inlineRunning = map[string]bool{}
+)
+
+func inlineModel() string {
+    if config.Inline.Model != "" {
+        return config.Inline.Model
+    }
+    return currentModel
+}
+
+func inlineDebounce() time.Duration {
+    if config.Inline.DebounceMs > 0 {
+        return time.Duration(config.Inline.DebounceMs) * time.Millisecond
+    }
+    return 800 * time.Millisecond
+}
+
+func inlineCacheTTL() time.Duration {
+    if config.Inline.CacheSeconds > 0 {
+        return time.Duration(config.Inline.CacheSeconds) * time.Second
+    }
+    return 5 * time.Minute
+}
+
+func inlineTimeout() time.Duration {
+    if config.Inline.TimeoutSeconds > 0 {
+        return time.Duration(config.Inline.TimeoutSeconds) * time.Second
+    }
+    return 8 * time.Second
+}
+
+// inlineCacheKey 按用户区分缓存，系统提示词中含有用户名字、时区等个人变量
+func inlineCacheKey(userID int64, model, question string) string {
+    return fmt.Sprintf("%d\x00%s\x00%s", userID, model, strings.ToLower(question))
+}
+
+// cachedInlineAnswer 返回未过期的缓存回答，并顺带清理过期条目
+func cachedInlineAnswer(key string) (inlineAnswer, bool) {
+    inlineMu.Lock()
+    defer inlineMu.Unlock()
+    for k, a := range inlineCache {
+        if time.Since(a.At) > inlineCacheTTL() {
+            delete(inlineCache, k)
+        }
+    }
+    a, ok := inlineCache[key]
+    return a, ok
+}
+
+// handleInlineQuery 处理内联查询：等待用户停止输入后请求模型，超时的回答会在后台完成并写入缓存
+func handleInlineQuery(bot *tgbotapi.BotAPI, query *tgbotapi.InlineQuery) {
+    if !isAllowed(query.From.ID, query.From.UserName) {
+        answerInlineHint(bot, query.ID, "🔒 你没有使用此机器人的权限")
+        return
+    }
+    question := strings.TrimSpace(query.Query)
+    if question == "" {
+        answerInlineHint(bot, query.ID, "输入问题，稍候即可获得 AI 回答")
+        return
+    }
+
+    // 用户每输入一个字符都会产生新的查询，只处理停止输入后的最后一个
+    inlineMu.Lock()
+    inlineLatest[query.From.ID] = query.ID
+    inlineMu.Unlock()
+    time.Sleep(inlineDebounce())
+    inlineMu.Lock()
+    latest := inlineLatest[query.From.ID] == query.ID
+    if latest {
+        delete(inlineLatest, query.From.ID)
+    }
+    inlineMu.Unlock()
+    if !latest {
+        return
+    }
+
+    role := userRole(query.From.ID)
+    model, ok := role.resolveModel(inlineModel())
+    if !ok {
+        answerInlineHint(bot, query.ID, fmt.Sprintf("🔒 你的角色（%s）不能使用内联模式", role.label()))
+        return
+    }
+    key := inlineCacheKey(query.From.ID, model, question)
+    if answer, ok := cachedInlineAnswer(key); ok {
+        logEvent("InlineCacheHit", map[string]interface{}{
+            "userID": query.From.ID,
+            "query":  question,
+        })
+        answerInlineQuery(bot, query.ID, question, answer)
+        return
+    }
+
+    inlineMu.Lock()
+    running := inlineRunning[key]
+    inlineRunning[key] = true
+    inlineMu.Unlock()
+    if running {
+        answerInlineHint(bot, query.ID, "⏳ 回答生成中，请稍后再次输入")
+        return
+    }
+    // 缓存命中不请求模型，只有实际生成回答时才计入额度
+    if exceeded := takeQuota(query.From, nil); exceeded != "" {
+        inlineMu.Lock()
+        delete(inlineRunning, key)
+        inlineMu.Unlock()
+        answerInlineHint(bot, query.ID, "⛔ "+exceeded)
+        return
+    }
+
+    logEvent("InlineQuery", map[string]interface{}{
+        "userID": query.From.ID,
+        "query":  question,
+        "model":  model,
+    })
+    done := make(chan inlineAnswer, 1)
+    go func() {
+        defer func() {
+            inlineMu.Lock()
+            delete(inlineRunning, key)
+            inlineMu.Unlock()
+        }()
+        answer, err := generateInlineAnswer(bot, query.From, role, model, question)
+        if err != nil {
+            logEvent("InlineCompletionError", err.Error())
+            close(done)
+            return
+        }
+        inlineMu.Lock()
+        inlineCache[key] = answer
+        inlineMu.Unlock()
+        done <- answer
+    }()
+
+    select {
+    case answer, ok := <-done:
+        if !ok {
+            answerInlineHint(bot, query.ID, "⚠️ 生成回答失败，请稍后重试")
+            return
+        }
+        answerInlineQuery(bot, query.ID, question, answer)
+    case <-time.After(inlineTimeout()):
+        answerInlineHint(bot, query.ID, "⏳ 回答生成中，请稍后再次输入")
+    }
+}
+
+func generateInlineAnswer(bot *tgbotapi.BotAPI, user *tgbotapi.User, role *RoleConfig, model, question string) (inlineAnswer, error) {
+    prompt := config.Inline.Prompt
+    if prompt == "" {
+        prompt = defaultInlinePrompt
+    }
+    vars := userPromptVars(chatLocation(user.ID), user)
+    vars.Model = model
+    now := time.Now()
+    messages := []Message{
+        {Role: "system", Content: renderSystemPrompt(prompt, vars), Time: now},
+        {Role: "user", Content: question, Time: now},
+    }
+    result, err := callOpenAI(completionRequest{ChatID: user.ID, Model: model, Messages: messages, NoTools: true, Role: role})
+    if err != nil {
+        return inlineAnswer{}, err
+    }
+    addUsage(user, nil, result)
+    // 内联查询没有所在的聊天，额度提醒发到用户的私聊
+    if warnings := quotaWarnings(user, nil); len(warnings) > 0 {
+        bot.Send(tgbotapi.NewMessage(user.ID, formatQuotaWarnings(warnings)))
+    }
+    return inlineAnswer{Model: result.Model, Content: result.Content, At: time.Now()}, nil
+}
+
+// answerInlineQuery 返回一条包含问题和回答的结果，格式化失败时改用纯文本
+func answerInlineQuery(bot *tgbotapi.BotAPI, queryID, question string, answer inlineAnswer) {
+    content := truncateRunes(answer.Content, inlineAnswerMaxRunes)
+    formatted := escapeMarkdownV2("❓ "+truncateRunes(question, 200)) + "\n\n" + mdToTgmd(content)
+    article := tgbotapi.NewInlineQueryResultArticleMarkdownV2("answer", truncateRunes(question, 64), formatted)
+    article.Description = truncateRunes(strings.Join(strings.Fields(content), " "), 100) + fmt.Sprintf("\n🤖 %s", answer.Model)
+
+    inline := tgbotapi.InlineConfig{
+        InlineQueryID: queryID,
+        Results:       []interface{}{article},
+        CacheTime:     int(inlineCacheTTL().Seconds()),
+        IsPersonal:    true,
+    }
+    if _, err := bot.Request(inline); err != nil {
+        logEvent("AnswerInlineQueryError", err.Error())
+        plain := tgbotapi.NewInlineQueryResultArticle("answer", truncateRunes(question, 64), "❓ "+truncateRunes(question, 200)+"\n\n"+content)
+        plain.Description = article.Description
+        inline.Results = []interface{}{plain}
+        if _, err := bot.Request(inline); err != nil {
+            logEvent("AnswerInlineQueryPlainError", err.Error())
+        }
+    }
+}
+
+// answerInlineHint 不返回结果，只在结果列表上方显示一行提示；缓存时间为 0 时 Telegram 会默认缓存 5 分钟，因此设为 1 秒
+func answerInlineHint(bot *tgbotapi.BotAPI, queryID, hint string) {
+    inline := tgbotapi.InlineConfig{
+        InlineQueryID:     queryID,
+        Results:           []interface{}{},
+        CacheTime:         1,
+        IsPersonal:        true,
+        SwitchPMText:      hint,
+        SwitchPMParameter: inlineStartParameter,
+    }
+    if _, err := bot.Request(inline); err != nil {
+        logEvent("AnswerInlineQueryError", err.Error())
+    }
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/base32"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Invite 是管理员生成的邀请码，通过 t.me/<机器人>?start=<邀请码> 兑换
+type Invite struct {
+    MaxUses    int          `json:"max_uses"` // 0 表示不限次数
+    Used       int          `json:"used"`
+    Role       string       `json:"role,omitempty"`
+    Quota      *QuotaLimits `json:"quota,omitempty"`
+    ExpiresAt  time.Time    `json:"expires_at"`
+    CreatedBy  int64        `json:"created_by"`
+    CreatedAt  time.Time    `json:"created_at"`
+    RedeemedBy []int64      `json:"redeemed_by,omitempty"`
+}
+
+const (
+    defaultInviteDays = 7
+    inviteUsage       = "用法：/invite [uses=次数] [days=有效天数] [role=角色] [daily=每天请求次数]\n" +
+        "uses=0 表示不限次数，默认单次使用、7 天有效\n" +
+        "/invite list 查看邀请码，/invite revoke <邀请码> 作废"
+)
+
+func newInviteCode() (string, error) {
+    b := make([]byte, 10)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
+
+func inviteLink(bot *tgbotapi.BotAPI, code string) string {
+    return fmt.Sprintf("https://t.me/%s?start=%s", bot.Self.UserName, code)
+}
+
+func (inv *Invite) usable(now time.Time) bool {
+    return now.Before(inv.ExpiresAt) && (inv.MaxUses == 0 || inv.Used < inv.MaxUses)
+}
+
+func (inv *Invite) summary() string {
+    uses := "不限次数"
+    if inv.MaxUses > 0 {
+        uses = fmt.Sprintf("%d/%d 次", inv.Used, inv.MaxUses)
+    } else if inv.Used > 0 {
+        uses = fmt.Sprintf("不限次数，已用 %d 次", inv.Used)
+    }
+    parts := []string{uses, "有效期至 " + inv.ExpiresAt.In(defaultLocation()).Format("01-02 15:04")}
+    if inv.Role != "" {
+        parts = append(parts, "角色 "+inv.Role)
+    }
+    if inv.Quota != nil {
+        parts = append(parts, "额度 "+inv.Quota.summary())
+    }
+    return strings.Join(parts, " · ")
+}
+
+// handleInviteCommand 处理 /invite：生成、列出或作废邀请码，只有管理员可以使用
+func handleInviteCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    chatID := message.Chat.ID
+    threadID := messageThreadID(message)
+    reply := func(text string) {
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, text), threadID)
+    }
+    if message.From == nil || !isAdmin(message.From.ID) {
+        reply("只有管理员可以使用此命令")
+        return
+    }
+
+    fields := strings.Fields(message.CommandArguments())
+    if len(fields) > 0 && fields[0] == "list" {
+        reply(inviteList(bot))
+        return
+    }
+    if len(fields) > 0 && fields[0] == "revoke" {
+        if len(fields) < 2 {
+            reply(inviteUsage)
+            return
+        }
+        stateMu.Lock()
+        a := accessLocked()
+        _, ok := a.Invites[fields[1]]
+        delete(a.Invites, fields[1])
+        saveStateLocked()
+        stateMu.Unlock()
+        if !ok {
+            reply("邀请码不存在")
+            return
+        }
+        reply("🗑 邀请码已作废")
+        return
+    }
+
+    now := time.Now()
+    inv := &Invite{MaxUses: 1, ExpiresAt: now.AddDate(0, 0, defaultInviteDays), CreatedBy: message.From.ID, CreatedAt: now}
+    for _, field := range fields {
+        kv := strings.SplitN(field, "=", 2)
+        if len(kv) != 2 {
+            reply(inviteUsage)
+            return
+        }
+        if kv[0] == "role" {
+            if findRole(kv[1]) == nil {
+                reply("未定义的角色：" + kv[1])
+                return
+            }
+            inv.Role = kv[1]
+            continue
+        }
+        n, err := strconv.Atoi(kv[1])
+        if err != nil || n < 0 {
+            reply(fmt.Sprintf("%s 必须是非负整数\n\n%s", kv[0], inviteUsage))
+            return
+        }
+        switch kv[0] {
+        case "uses":
+            inv.MaxUses = n
+        case "days":
+            if n == 0 {
+                reply("days 必须大于 0")
+                return
+            }
+            inv.ExpiresAt = now.AddDate(0, 0, n)
+        case "daily":
+            inv.Quota = &QuotaLimits{DailyRequests: n}
+        default:
+            reply(inviteUsage)
+            return
+        }
+    }
+
+    code, err := newInviteCode()
+    if err != nil {
+        logEvent("NewInviteCodeError", err.Error())
+        reply("生成邀请码失败")
+        return
+    }
+    stateMu.Lock()
+    a := accessLocked()
+    // 顺带清理已失效的邀请码
+    for c, old := range a.Invites {
+        if !old.usable(now) {
+            delete(a.Invites, c)
+        }
+    }
+    a.Invites[code] = inv
+    saveStateLocked()
+    stateMu.Unlock()
+
+    logEvent("InviteCreated", map[string]interface{}{
+        "by":      message.From.ID,
+        "maxUses": inv.MaxUses,
+        "role":    inv.Role,
+    })
+    reply(fmt.Sprintf("🎟 邀请链接已生成\n%s\n%s", inviteLink(bot, code), inv.summary()))
+}
+
+func inviteList(bot *tgbotapi.BotAPI) string {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    a := accessLocked()
+    now := time.Now()
+    codes := make([]string, 0, len(a.Invites))
+    for code, inv := range a.Invites {
+        if inv.usable(now) {
+            codes = append(codes, code)
+        }
+    }
+    if len(codes) == 0 {
+        return "没有可用的邀请码"
+    }
+    sort.Slice(codes, func(i, j int) bool {
+        return a.Invites[codes[i]].CreatedAt.Before(a.Invites[codes[j]].CreatedAt)
+    })
+    var sb strings.Builder
+    sb.WriteString("🎟 可用的邀请码\n")
+    for _, code := range codes {
+        sb.WriteString(fmt.Sprintf("\n%s\n%s\n", code, a.Invites[code].summary()))
+    }
+    return sb.String()
+}
+
+// redeemInvite 处理 /start 携带的邀请码，兑换成功时把用户加入访问列表；没有携带邀请码时返回 false
+func redeemInvite(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+    code := strings.TrimSpace(message.CommandArguments())
+    if code == "" || code == inlineStartParameter || message.From == nil || message.Chat.Type != "private" {
+        return false
+    }
+    user := message.From
+    key := chatKey(user.ID)
+    now := time.Now()
+
+    var text string
+    stateMu.Lock()
+    a := accessLocked()
+    inv, ok := a.Invites[code]
+    redeemed := false
+    if ok {
+        for _, id := range inv.RedeemedBy {
+            if id == user.ID {
+                redeemed = true
+            }
+        }
+    }
+    switch {
+    case !ok || !inv.usable(now):
+        text = "❌ 邀请码无效或已过期"
+    case accessOpen() || isAdmin(user.ID) || accessListedLocked(user.ID, user.UserName):
+        text = "你已经可以使用此机器人"
+    case redeemed || a.Revoked[key]:
+        // 被 /deny 移除的用户不能靠邀请码恢复权限，需管理员重新 /allow
+        text = "❌ 无法使用此邀请码，请联系管理员"
+    default:
+        inv.Used++
+        inv.RedeemedBy = append(inv.RedeemedBy, user.ID)
+        a.Users[key] = &AccessEntry{Note: userDisplayName(user), AddedBy: inv.CreatedBy, AddedAt: now, Role: inv.Role, Quota: inv.Quota}
+        if r := a.Requests[key]; r != nil && r.Status == requestPending {
+            r.Status, r.DecidedAt = requestApproved, now
+        }
+        saveStateLocked()
+        text = "🎉 邀请码兑换成功，现在可以开始对话了"
+        if inv.Quota != nil {
+            text += "\n额度：" + inv.Quota.summary()
+        }
+    }
+    createdBy := int64(0)
+    if ok {
+        createdBy = inv.CreatedBy
+    }
+    stateMu.Unlock()
+
+    logEvent("InviteRedeemAttempt", map[string]interface{}{
+        "userID": user.ID,
+        "result": text,
+    })
+    bot.Send(tgbotapi.NewMessage(message.Chat.ID, text))
+    if strings.HasPrefix(text, "🎉") && createdBy != 0 {
+        bot.Send(tgbotapi.NewMessage(createdBy, fmt.Sprintf("🎟 %s（%d）通过你的邀请码加入了", userDisplayName(user), user.ID)))
+    }
+    return true
+}
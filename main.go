@@ -26,11 +26,54 @@ type Config struct {
     HistoryTimeoutMinutes int          `yaml:"history_timeout_minutes"`
     AllowedUsers          []int64      `yaml:"allowed_users"`
     AllowedChannels       []string     `yaml:"allowed_channels"`
+    Admins                []int64      `yaml:"admins"`
+    Roles                 []RoleConfig `yaml:"roles"`
+    DefaultRole           string       `yaml:"default_role"`
+    AccessRequests        AccessRequestsConfig `yaml:"access_requests"`
+    Quotas                QuotasConfig `yaml:"quotas"`
+    CircuitBreaker        CircuitBreakerConfig `yaml:"circuit_breaker"`
+    Timezone              string       `yaml:"timezone"`
+    Tools                 ToolsConfig  `yaml:"tools"`
+    MCPServers            []MCPServerConfig `yaml:"mcp_servers"`
+    DataFile              string       `yaml:"data_file"`
+    URLFetch              URLFetchConfig `yaml:"url_fetch"`
+    Search                SearchConfig `yaml:"search"`
+    Sessions              SessionsConfig `yaml:"sessions"`
+    Presets               []PresetConfig `yaml:"presets"`
+    ModelParams           []ModelParamsRule `yaml:"model_params"`
+    Reasoning             ReasoningConfig `yaml:"reasoning"`
+    Compare               CompareConfig `yaml:"compare"`
+    AutoRoute             AutoRouteConfig `yaml:"auto_route"`
+    ModelPicker           ModelPickerConfig `yaml:"model_picker"`
+    Models                ModelsConfig `yaml:"models"`
+    Groups                GroupsConfig `yaml:"groups"`
+    Inline                InlineModeConfig `yaml:"inline"`
+    Channels              []ChannelConfig `yaml:"channels"`
+}
+
+type SessionsConfig struct {
+    MaxSessions    int    `yaml:"max_sessions"`
+    TitleModel     string `yaml:"title_model"`
+    DisableAITitle bool   `yaml:"disable_ai_title"`
 }
 
 type OpenAIConfig struct {
     APIKey string `yaml:"api_key"`
     APIURL string `yaml:"api_url"`
+    Stream bool   `yaml:"stream"`
+}
+
+type CircuitBreakerConfig struct {
+    Disabled         bool `yaml:"disabled"`
+    FailureThreshold int  `yaml:"failure_threshold"`
+    OpenSeconds      int  `yaml:"open_seconds"`
+    HalfOpenRequests int  `yaml:"half_open_requests"`
+}
+
+type ToolsConfig struct {
+    Enabled  bool     `yaml:"enabled"`
+    MaxSteps int      `yaml:"max_steps"`
+    Builtin  []string `yaml:"builtin"`
 }
 
 type OpenAIModel struct {
@@ -45,27 +88,74 @@ type OpenAIModelResponse struct {
 }
 
 type OpenAIRequest struct {
-    Model    string    `json:"model"`
-    Messages []Message `json:"messages"`
+    Model         string               `json:"model"`
+    Messages      []Message            `json:"messages"`
+    Tools         []ToolDefinition     `json:"tools,omitempty"`
+    Stream        bool                 `json:"stream,omitempty"`
+    StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+    GenerationParams
+}
+
+type OpenAIStreamOptions struct {
+    IncludeUsage bool `json:"include_usage"`
 }
 
 type Message struct {
-    Role    string    `json:"role"`
-    Content string    `json:"content"`
-    Time    time.Time `json:"time"`
+    Role       string     `json:"role"`
+    Content    string     `json:"content"`
+    Name       string     `json:"name,omitempty"`
+    ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+    ToolCallID string     `json:"tool_call_id,omitempty"`
+    Time       time.Time  `json:"time"`
+    TgMsgID    int        `json:"tg_msg_id,omitempty"` // 对应的 Telegram 消息 ID，用于回复分支，不发送给模型
 }
 
 type OpenAIResponse struct {
-    Choices []struct {
-        Message struct {
-            Content string `json:"content"`
-        } `json:"message"`
-    } `json:"choices"`
-    Usage *struct {
-        PromptTokens     int `json:"prompt_tokens"`
-        CompletionTokens int `json:"completion_tokens"`
-        TotalTokens      int `json:"total_tokens"`
-    } `json:"usage"`
+    Choices []OpenAIChoice `json:"choices"`
+    Usage   *OpenAIUsage   `json:"usage"`
+}
+
+type OpenAIChoice struct {
+    Message struct {
+        Content          string     `json:"content"`
+        ReasoningContent string     `json:"reasoning_content"`
+        Reasoning        string     `json:"reasoning"`
+        ToolCalls        []ToolCall `json:"tool_calls"`
+    } `json:"message"`
+    FinishReason string `json:"finish_reason"`
+}
+
+type OpenAIUsage struct {
+    PromptTokens            int `json:"prompt_tokens"`
+    CompletionTokens        int `json:"completion_tokens"`
+    TotalTokens             int `json:"total_tokens"`
+    CompletionTokensDetails *struct {
+        ReasoningTokens int `json:"reasoning_tokens"`
+    } `json:"completion_tokens_details"`
+}
+
+// completionRequest 描述一次对话补全请求
+type completionRequest struct {
+    ChatID   int64
+    Model    string
+    Params   GenerationParams
+    Messages []Message
+    NoTools  bool
+    HasImage bool
+    Role     *RoleConfig // 非空时自动路由选出的模型也需在角色允许的范围内
+}
+
+// CompletionResult 汇总一次对话补全（含工具调用的多轮请求）的结果
+type CompletionResult struct {
+    Model           string
+    Content         string
+    InputTokens     int
+    OutputTokens    int
+    IsAPITokenCount bool
+    ToolNotes       []toolNote
+    Reasoning       string
+    ReasoningTokens int
+    RouteReason     string
 }
 
 type OpenAIErrorResponse struct {
@@ -80,13 +170,9 @@ type OpenAIErrorResponse struct {
 var (
     config                  Config
     currentModel            string
-    conversationHistory     []Message
-    availableModels         []OpenAIModel
     version                 string
     systemPrompt            string
-    remainingRounds         int
     startTime               time.Time
-    interactionTime         time.Time
     totalInputTokens        int
     totalOutputTokens       int
 )
@@ -102,23 +188,35 @@ func main() {
 
     loadConfig()
     loadVersion()
+    loadState()
+    if config.Tools.Enabled {
+        registerBuiltinTools()
+    }
+    if config.Search.Enabled {
+        registerSearchTool()
+    }
+    startMCPServers()
 
     systemPrompt = config.SystemPrompt
-    remainingRounds = config.HistoryLength
     startTime = time.Now()
-    interactionTime = startTime
 
     logEvent("ConfigLoaded", map[string]interface{}{
         "systemPrompt": systemPrompt,
     })
-
-    availableModels = getOpenAIModels()
-    if config.DefaultModel != "" {
-        currentModel = config.DefaultModel
-    } else if len(availableModels) > 0 {
-        currentModel = availableModels[0].ID
+    if err := validatePromptTemplate(systemPrompt); err != nil {
+        logEvent("InvalidPromptTemplate", map[string]interface{}{"prompt": "system_prompt", "error": err.Error()})
+    }
+    for _, preset := range config.Presets {
+        if err := validatePromptTemplate(preset.Prompt); err != nil {
+            logEvent("InvalidPromptTemplate", map[string]interface{}{"prompt": preset.Name, "error": err.Error()})
+        }
     }
 
+    models := refreshModels()
+    currentModel = config.DefaultModel
+    validateDefaultModel(models)
+    startModelRefresher()
+
     bot, err := tgbotapi.NewBotAPI(config.TelegramToken)
     if err != nil {
         logEvent("BotInitError", err)
@@ -135,27 +233,75 @@ func main() {
     //初始化机器人菜单
 setCommands(bot)
 
-    if systemPrompt != "" {
-        conversationHistory = append(conversationHistory, Message{Role: "system", Content: systemPrompt, Time: time.Now()})
+    breakerStateHook = func(endpoint string, from, to breakerState, lastError string) {
+        notifyBreakerStateChange(bot, endpoint, from, to, lastError)
     }
 
     for _, userID := range config.AllowedUsers {
-        sendInitInfo(bot, userID)
+        sendInitInfo(bot, userID, chatKey(userID))
     }
 
     u := tgbotapi.NewUpdate(0)
     u.Timeout = 60
-    updates := bot.GetUpdatesChan(u)
+    updates := getUpdatesChan(bot, u)
 
     for update := range updates {
+        // 被封禁的用户在任何聊天中都不响应
+        if user := update.SentFrom(); user != nil && isBanned(user.ID) {
+            continue
+        }
+        if update.InlineQuery != nil {
+            if config.Inline.Enabled {
+                go handleInlineQuery(bot, update.InlineQuery)
+            }
+            continue
+        }
         if update.CallbackQuery != nil {
             handleCallbackQuery(bot, update.CallbackQuery)
             continue
         }
+        if update.ChannelPost != nil {
+            go handleChannelPost(bot, update.ChannelPost)
+            continue
+        }
+        if update.EditedMessage != nil {
+            if editedMessageAccepted(bot, update.EditedMessage) {
+                go handleEditedMessage(bot, update.EditedMessage)
+            }
+            continue
+        }
         if update.Message == nil {
             continue
         }
+        // 频道帖子自动转发到讨论群时，由频道配置决定是否评论
+        if isDiscussionForward(update.Message) {
+            go handleDiscussionForward(bot, update.Message)
+            continue
+        }
+        // 群聊中只响应发给本机器人的命令，以及 @提及、回复或以触发词开头的消息
+        if isGroupChat(update.Message.Chat) {
+            if !isGroupAllowed(update.Message.Chat) {
+                continue
+            }
+            if update.Message.IsCommand() {
+                if !commandForOtherBot(bot, update.Message) {
+                    handleCommand(bot, update.Message)
+                }
+                continue
+            }
+            text, triggered := groupTriggered(bot, update.Message)
+            if !triggered {
+                continue
+            }
+            update.Message.Text = text
+            go handleMessage(bot, update.Message)
+            continue
+        }
         if !isAllowed(update.Message.Chat.ID, update.Message.Chat.UserName) {
+            if update.Message.Command() == "start" && redeemInvite(bot, update.Message) {
+                continue
+            }
+            offerAccessRequest(bot, update.Message)
             continue
         }
         if update.Message.IsCommand() {
@@ -173,11 +319,63 @@ func setCommands(bot *tgbotapi.BotAPI) {
         },
         {
             Command:     "models",
-            Description: "查看可用的模型列表",
+            Description: "查看可用的模型列表，可附加关键词搜索",
+        },
+        {
+            Command:     "new",
+            Description: "开始新会话",
+        },
+        {
+            Command:     "sessions",
+            Description: "查看并切换会话",
         },
         {
             Command:     "clear",
-            Description: "清除对话历史",
+            Description: "清除当前会话的对话历史",
+        },
+        {
+            Command:     "system",
+            Description: "查看或设置系统提示词",
+        },
+        {
+            Command:     "persona",
+            Description: "选择人设预设",
+        },
+        {
+            Command:     "timezone",
+            Description: "查看或设置聊天时区",
+        },
+        {
+            Command:     "params",
+            Description: "调整生成参数",
+        },
+        {
+            Command:     "reasoning",
+            Description: "设置思考过程的显示方式",
+        },
+        {
+            Command:     "compare",
+            Description: "多模型对比回答",
+        },
+        {
+            Command:     "status",
+            Description: "查看运行与上游状态",
+        },
+        {
+            Command:     "search",
+            Description: "联网搜索并回答：/search <内容>",
+        },
+        {
+            Command:     "summarize",
+            Description: "抓取并总结网页：/summarize <链接>",
+        },
+        {
+            Command:     "mcp",
+            Description: "管理本聊天启用的 MCP 工具服务器",
+        },
+        {
+            Command:     "usage",
+            Description: "查看使用额度",
         },
     }
 
@@ -190,6 +388,18 @@ func setCommands(bot *tgbotapi.BotAPI) {
             "commands": commands,
         })
     }
+
+    // 管理命令只显示在管理员的私聊菜单中
+    adminCommands := append(append([]tgbotapi.BotCommand{}, commands...), adminBotCommands...)
+    for _, adminID := range adminIDs() {
+        cmd := tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeChat(adminID), adminCommands...)
+        if _, err := bot.Request(cmd); err != nil {
+            logEvent("SetAdminCommandsError", map[string]interface{}{
+                "adminID": adminID,
+                "error":   err.Error(),
+            })
+        }
+    }
 }
 func loadConfig() {
     configFile, err := ioutil.ReadFile("/app/config/config.yaml")
@@ -210,109 +420,224 @@ func loadVersion() {
     version = strings.TrimSpace(string(versionFile))
 }
 
-func isAllowed(chatID int64, chatUsername string) bool {
-    if len(config.AllowedUsers) == 0 && len(config.AllowedChannels) == 0 {
-        return true
-    }
-    for _, id := range config.AllowedUsers {
-        if id == chatID {
-            return true
-        }
-    }
-    for _, channel := range config.AllowedChannels {
-        if channel == chatUsername {
-            return true
-        }
-    }
-    return false
-}
-
 func handleCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    if commandDenied(bot, message) {
+        return
+    }
     switch message.Command() {
     case "start":
-        sendInitInfo(bot, message.Chat.ID)
+        if redeemInvite(bot, message) {
+            return
+        }
+        sendInitInfo(bot, message.Chat.ID, sessionKeyFor(message))
     case "models":
-        sendModelList(bot, message.Chat.ID)
+        sendModelList(bot, message, strings.TrimSpace(message.CommandArguments()))
+    case "new":
+        startNewSession(bot, message.Chat.ID, sessionKeyFor(message))
+    case "sessions":
+        sendSessionList(bot, message.Chat.ID, sessionKeyFor(message), 0)
     case "clear":
-        clearConversationHistory(bot, message.Chat.ID)
+        clearConversationHistory(bot, message.Chat.ID, sessionKeyFor(message))
+    case "system":
+        handleSystemCommand(bot, message)
+    case "persona":
+        sendPersonaList(bot, message.Chat.ID, messageThreadID(message))
+    case "timezone":
+        handleTimezoneCommand(bot, message)
+    case "params":
+        handleParamsCommand(bot, message)
+    case "reasoning":
+        handleReasoningCommand(bot, message)
+    case "compare":
+        handleCompareCommand(bot, message)
+    case "status":
+        sendStatus(bot, message.Chat.ID, sessionKeyFor(message))
+    case "search":
+        go handleSearchCommand(bot, message)
+    case "summarize":
+        go summarizeURL(bot, message)
+    case "mcp":
+        sendMCPServerList(bot, message.Chat.ID, messageThreadID(message), 0)
+    case "allow", "deny", "ban", "users":
+        handleAccessCommand(bot, message)
+    case "invite":
+        handleInviteCommand(bot, message)
+    case "usage":
+        handleUsageCommand(bot, message)
     }
 }
 
 func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    if role, ok := admitMessage(bot, message); ok {
+        respondToMessage(bot, message, 0, role)
+    }
+}
+
+// admitMessage 在修改会话前检查发送者的角色和额度，不通过时告知用户并返回 false；通过时已计入一次请求
+func admitMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) (*RoleConfig, bool) {
+    role := messageRole(message)
+    if hasUpload(message) && !role.allowsUploads() {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("你的角色（%s）不能上传图片或文件", role.label())), messageThreadID(message))
+        return nil, false
+    }
+    if _, ok := roleModel(bot, message, role, sessionModel(sessionKeyFor(message))); !ok {
+        return nil, false
+    }
+    if !consumeQuota(bot, message) {
+        return nil, false
+    }
+    return role, true
+}
+
+// respondToMessage 请求模型并回复消息，调用前需通过 admitMessage；answerMsgID 非零时原地编辑这条旧回答，而不是发送新消息
+func respondToMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, answerMsgID int, role *RoleConfig) {
     logEvent("ReceivedMessage", map[string]interface{}{
         "text": message.Text,
     })
+    key := sessionKeyFor(message)
     start := time.Now()
 
     now := time.Now()
-
-    var newHistory []Message
-    cutoffTime := now.Add(-time.Duration(config.HistoryTimeoutMinutes) * time.Minute)
-    for _, msg := range conversationHistory {
-        if msg.Time.After(cutoffTime) {
-            newHistory = append(newHistory, msg)
-        }
+    content := message.Text
+    // 网页内容只随本次请求发送，不写入会话历史
+    pages := linkedPagesContext(message)
+
+    var branchNote string
+    // 编辑过的消息已在 handleEditedMessage 中处理过分支
+    if message.EditDate == 0 && message.ReplyToMessage != nil && message.ReplyToMessage.From != nil && message.ReplyToMessage.From.ID == bot.Self.ID {
+        branchNote = branchOnReply(key, message.ReplyToMessage.MessageID)
     }
-    conversationHistory = newHistory
 
-    conversationHistory = append(conversationHistory, Message{Role: "user", Content: message.Text, Time: now})
+    vars := newPromptVars(message)
+    var req completionRequest
+    var sessionID string
+    var remainingRounds int
+    var interactionTime time.Time
+    var firstTurn bool
+    var model string
+    modelAllowed := true
+    withActiveSession(key, func(sess *Session) {
+        // 回复分支后的会话可能使用别的模型，在写入历史前再按角色确认一次
+        if model, modelAllowed = role.resolveModel(sess.Model); !modelAllowed {
+            model = sess.Model
+            return
+        }
+        sess.pruneExpired(now)
+        if sess.RemainingRounds > 0 {
+            sess.RemainingRounds--
+        } else {
+            sess.reset(now)
+        }
+        userMsg := Message{Role: "user", Content: content, Time: now, TgMsgID: message.MessageID}
+        if isGroupChat(message.Chat) {
+            userMsg.Name = messageName(message.From)
+        }
+        sess.History = append(sess.History, userMsg)
 
-    if remainingRounds > 0 {
-        remainingRounds--
-    } else {
-        remainingRounds = config.HistoryLength
-        conversationHistory = conversationHistory[:0]
-        if systemPrompt != "" {
-            conversationHistory = append(conversationHistory, Message{Role: "system", Content: systemPrompt, Time: now})
+        if time.Since(sess.InteractionTime).Minutes() >= float64(config.HistoryTimeoutMinutes) {
+            sess.InteractionTime = now
+        }
+        sess.UpdatedAt = now
+        if sess.Title == "" {
+            firstTurn = true
+            sess.Title = fallbackTitle(message.Text)
         }
+
+        sessionID = sess.ID
+        remainingRounds = sess.RemainingRounds
+        interactionTime = sess.InteractionTime
+        req = completionRequest{ChatID: message.Chat.ID, Model: sess.Model, Params: sess.Params, Messages: sess.requestMessages(vars), HasImage: len(message.Photo) > 0, Role: role}
+    })
+    if !modelAllowed {
+        roleModel(bot, message, role, model)
+        return
+    }
+    if pages != "" {
+        last := &req.Messages[len(req.Messages)-1]
+        last.Content += pages
+    }
+    if role != nil {
+        // 角色不能使用会话模型时改用角色的默认模型，会话本身的设置不变
+        req.Model = model
+        req.NoTools = !role.allowsCommand("tools")
+        req.Messages = trimContext(req.Messages, role.MaxContextMessages)
     }
 
-    var response string
-    var inputTokens, outputTokens int
-    var isAPITokenCount bool
-    var err error
+    var result CompletionResult
+    var callErr error
 
     wg := sync.WaitGroup{}
     wg.Add(1)
 
     go func() {
         defer wg.Done()
-        response, inputTokens, outputTokens, isAPITokenCount, err = callOpenAIWithRetry(conversationHistory)
+        result, callErr = callOpenAIWithRetry(req)
     }()
 
     wg.Wait()
     duration := time.Since(start)
 
-    if time.Since(interactionTime).Minutes() >= float64(config.HistoryTimeoutMinutes) {
-        interactionTime = time.Now()
-    }
-
     remainingTime := config.HistoryTimeoutMinutes*60 - int(time.Since(interactionTime).Seconds())
 
     remainingMinutes := remainingTime / 60
     remainingSeconds := remainingTime % 60
 
-    totalInputTokens += inputTokens
-    totalOutputTokens += outputTokens
 
+    if callErr == nil {
+        recordUsage(bot, message, result)
+    }
+
+    mode := reasoningMode(message.Chat.ID)
     var formattedResponse string
-    if err != nil {
-        formattedResponse = fmt.Sprintf("抱歉，发生了错误：%s\n请检查日志以获取更多信息。", escapeMarkdownV2(err.Error()))
+    if callErr != nil {
+        formattedResponse = fmt.Sprintf("抱歉，发生了错误：%s\n请检查日志以获取更多信息。", escapeMarkdownV2(callErr.Error()))
     } else {
-        formattedResponse = formatResponse(response, inputTokens, outputTokens, isAPITokenCount, duration, remainingRounds, remainingMinutes, remainingSeconds)
+        formattedResponse = formatResponse(result, duration, remainingRounds, remainingMinutes, remainingSeconds, mode == reasoningShow)
+        // 思考过程和回答合起来超出消息长度时，这一条改为以文件发送思考过程
+        if mode == reasoningShow && result.Reasoning != "" && messageTooLong(formattedResponse) {
+            mode = reasoningFile
+            formattedResponse = formatResponse(result, duration, remainingRounds, remainingMinutes, remainingSeconds, false)
+        }
+    }
+    if callErr == nil && result.Reasoning != "" && mode == reasoningFile {
+        sendReasoningFile(bot, message.Chat.ID, messageThreadID(message), message.MessageID, result.Reasoning)
     }
+    if branchNote != "" {
+        formattedResponse = escapeMarkdownV2(branchNote) + "\n\n" + formattedResponse
+    }
+
+    var sentMsg tgbotapi.Message
+    if answerMsgID != 0 && editAnswer(bot, message.Chat.ID, answerMsgID, formattedResponse, result.Content) {
+        sentMsg.MessageID = answerMsgID
+    } else {
+        sentMsg = sendAnswer(bot, message, formattedResponse, result.Content)
+    }
+
+    if callErr == nil {
+        // 记录回复对应的消息 ID，之后回复这条消息即可从此处分支
+        withSession(key, sessionID, func(sess *Session) {
+            sess.History = append(sess.History, Message{Role: "assistant", Content: result.Content, Time: time.Now(), TgMsgID: sentMsg.MessageID})
+            sess.UpdatedAt = time.Now()
+        })
+        if firstTurn {
+            go generateSessionTitle(message.Chat.ID, key, sessionID, result.Model, message.Text, result.Content)
+        }
+    }
+}
 
-    msg := tgbotapi.NewMessage(message.Chat.ID, formattedResponse)
+// sendAnswer 以 MarkdownV2 发送回答，失败时改发未格式化的文本
+func sendAnswer(bot *tgbotapi.BotAPI, message *tgbotapi.Message, formatted, plain string) tgbotapi.Message {
+    msg := tgbotapi.NewMessage(message.Chat.ID, formatted)
     msg.ParseMode = "MarkdownV2"
     logEvent("SendingMessage", map[string]interface{}{
-        "text": formattedResponse,
+        "text": formatted,
     })
-    sentMsg, err := bot.Send(msg)
+    sentMsg, err := sendThreaded(bot, msg, messageThreadID(message))
     if err != nil {
         logEvent("SendMessageError", err)
-        plainMsg := tgbotapi.NewMessage(message.Chat.ID, "抱歉，在发送格式化消息时遇到了问题。这是未格式化的回复：\n\n"+response)
+        plainMsg := tgbotapi.NewMessage(message.Chat.ID, "抱歉，在发送格式化消息时遇到了问题。这是未格式化的回复：\n\n"+plain)
         plainMsg.ParseMode = ""
-        sentMsg, err = bot.Send(plainMsg)
+        sentMsg, err = sendThreaded(bot, plainMsg, messageThreadID(message))
         if err != nil {
             logEvent("SendPlainMessageError", err)
         } else {
@@ -321,104 +646,137 @@ func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
     } else {
         logSentMessage(sentMsg)
     }
+    return sentMsg
 }
 
-func sendInitInfo(bot *tgbotapi.BotAPI, chatID int64) {
+func sendInitInfo(bot *tgbotapi.BotAPI, chatID int64, key string) {
+    model, params := sessionParams(key)
     initInfo := fmt.Sprintf(
         "🤖 机器人初始化信息 🤖\n"+
             "──────────────\n"+
             "📅  启动时间: %s\n"+
             "🔢  系统版本: %s\n"+
             "⚙️  当前模型: %s\n"+
+            "🎛  生成参数: %s\n"+
             "🌐  API地址: %s\n"+
             "🔄  轮数限制: %d\n"+
             "⏲️  记忆保留: %d 分钟\n"+
             "──────────────",
-        startTime.Format("2006-01-02 15:04:05"), version, currentModel, config.OpenAIConfig.APIURL, config.HistoryLength, config.HistoryTimeoutMinutes)
+        startTime.Format("2006-01-02 15:04:05"), version, model, params.summary(), config.OpenAIConfig.APIURL, config.HistoryLength, config.HistoryTimeoutMinutes)
     msg := tgbotapi.NewMessage(chatID, escapeMarkdownV2(initInfo))
     msg.ParseMode = "MarkdownV2"
-    bot.Send(msg)
+    sendThreaded(bot, msg, keyThreadID(key))
 }
 
-func sendModelList(bot *tgbotapi.BotAPI, chatID int64) {
-    logEvent("SendingModelList", map[string]interface{}{
-        "chatID": chatID,
-    })
-
-    availableModels = getOpenAIModels()
-    var keyboard [][]tgbotapi.InlineKeyboardButton
-    for i := 0; i < len(availableModels); i += 2 {
-        row := []tgbotapi.InlineKeyboardButton{
-            tgbotapi.NewInlineKeyboardButtonData(availableModels[i].ID, "model:"+availableModels[i].ID),
+func sendStatus(bot *tgbotapi.BotAPI, chatID int64, key string) {
+    var sb strings.Builder
+    sb.WriteString("📡 运行状态 📡\n")
+    sb.WriteString("──────────────\n")
+    sb.WriteString(fmt.Sprintf("⏱  运行时长: %s\n", time.Since(startTime).Round(time.Second)))
+    sb.WriteString(fmt.Sprintf("⚙️  当前模型: %s\n", sessionModel(key)))
+    sb.WriteString(fmt.Sprintf("🌐  API地址: %s\n", config.OpenAIConfig.APIURL))
+    sb.WriteString("🔌  上游熔断器:\n")
+
+    snapshots := breakerSnapshots()
+    if len(snapshots) == 0 {
+        sb.WriteString("    暂无请求记录\n")
+    }
+    for _, s := range snapshots {
+        sb.WriteString(fmt.Sprintf("    %s  %s  失败: %d/%d\n", s.Endpoint, s.State.Label(), s.Failures, breakerThreshold()))
+        if s.State == breakerOpen {
+            sb.WriteString(fmt.Sprintf("      剩余熔断时间: %d 秒\n", int(s.RetryAfter.Seconds())+1))
         }
-        if i+1 < len(availableModels) {
-            row = append(row, tgbotapi.NewInlineKeyboardButtonData(availableModels[i+1].ID, "model:"+availableModels[i+1].ID))
+        if s.State != breakerClosed && s.LastError != "" {
+            sb.WriteString(fmt.Sprintf("      最近错误: %s\n", s.LastError))
         }
-        keyboard = append(keyboard, row)
     }
+    sb.WriteString("──────────────")
 
-    msg := tgbotapi.NewMessage(chatID, "请选择一个模型:")
-    msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-
-    sentMsg, err := bot.Send(msg)
-    if err != nil {
-        logEvent("SendModelListError", err)
-    } else {
-        logEvent("ModelListSent", map[string]interface{}{
-            "message": sentMsg,
-        })
+    msg := tgbotapi.NewMessage(chatID, escapeMarkdownV2(sb.String()))
+    msg.ParseMode = "MarkdownV2"
+    if _, err := sendThreaded(bot, msg, keyThreadID(key)); err != nil {
+        logEvent("SendStatusError", err)
     }
 }
 
-func clearConversationHistory(bot *tgbotapi.BotAPI, chatID int64) {
-    conversationHistory = []Message{}
-    remainingRounds = config.HistoryLength
-    interactionTime = time.Now()
-    if systemPrompt != "" {
-        conversationHistory = append(conversationHistory, Message{Role: "system", Content: systemPrompt, Time: interactionTime})
+// notifyAdmins 向管理员发送通知
+func notifyAdmins(bot *tgbotapi.BotAPI, text string) {
+    for _, userID := range adminIDs() {
+        msg := tgbotapi.NewMessage(userID, text)
+        if _, err := bot.Send(msg); err != nil {
+            logEvent("NotifyAdminError", map[string]interface{}{
+                "userID": userID,
+                "error":  err.Error(),
+            })
+        }
     }
-    msg := tgbotapi.NewMessage(chatID, "对话记忆已清除")
-    bot.Send(msg)
 }
 
-func getOpenAIModels() []OpenAIModel {
-    client := &http.Client{}
-    req, err := http.NewRequest("GET", config.OpenAIConfig.APIURL+"/models", nil)
-    if err != nil {
-        logEvent("GetModelsRequestError", err)
-        return nil
+func notifyBreakerStateChange(bot *tgbotapi.BotAPI, endpoint string, from, to breakerState, lastError string) {
+    var text string
+    switch to {
+    case breakerOpen:
+        text = fmt.Sprintf("⚠️ 上游熔断已触发\n接口: %s\n状态: %s → %s\n熔断时长: %d 秒\n最近错误: %s",
+            endpoint, from.Label(), to.Label(), int(breakerOpenDuration().Seconds()), lastError)
+    case breakerClosed:
+        text = fmt.Sprintf("✅ 上游已恢复\n接口: %s\n状态: %s → %s", endpoint, from.Label(), to.Label())
+    default:
+        // 半开状态只是探测，不打扰管理员
+        return
     }
-    req.Header.Add("Authorization", "Bearer "+config.OpenAIConfig.APIKey)
+    notifyAdmins(bot, text)
+}
 
-    resp, err := client.Do(req)
-    if err != nil {
-        logEvent("GetModelsResponseError", err)
-        return nil
-    }
-    defer resp.Body.Close()
+// sendModelList 显示发送者的角色可以使用的模型，query 非空时只显示 ID 包含该关键词的模型
+func sendModelList(bot *tgbotapi.BotAPI, message *tgbotapi.Message, query string) {
+    logEvent("SendingModelList", map[string]interface{}{
+        "chatID": message.Chat.ID,
+        "query":  query,
+    })
 
-    body, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        logEvent("ReadModelsBodyError", err)
-        return nil
+    view := modelView{Kind: "root"}
+    if query != "" {
+        view = modelView{Kind: "search", Value: query}
     }
+    sendModelPicker(bot, message.Chat.ID, sessionKeyFor(message), messageRole(message), 0, view)
+}
 
-    var modelResp OpenAIModelResponse
-    err = json.Unmarshal(body, &modelResp)
-    if err != nil {
-        logEvent("UnmarshalModelsError", err)
-        return nil
+// modelKeyboard 把模型排成每行两个按钮，button 决定每个按钮的文字和回调数据
+func modelKeyboard(models []OpenAIModel, button func(i int, model OpenAIModel) tgbotapi.InlineKeyboardButton) [][]tgbotapi.InlineKeyboardButton {
+    var keyboard [][]tgbotapi.InlineKeyboardButton
+    for i := 0; i < len(models); i += 2 {
+        row := []tgbotapi.InlineKeyboardButton{button(i, models[i])}
+        if i+1 < len(models) {
+            row = append(row, button(i+1, models[i+1]))
+        }
+        keyboard = append(keyboard, row)
     }
+    return keyboard
+}
 
-    return modelResp.Data
+func clearConversationHistory(bot *tgbotapi.BotAPI, chatID int64, key string) {
+    withActiveSession(key, func(sess *Session) {
+        sess.reset(time.Now())
+    })
+    msg := tgbotapi.NewMessage(chatID, "对话记忆已清除")
+    sendThreaded(bot, msg, keyThreadID(key))
 }
 
-func callOpenAIWithRetry(history []Message) (string, int, int, bool, error) {
+func callOpenAIWithRetry(req completionRequest) (CompletionResult, error) {
     var lastErr error
+    cb := getBreaker("/chat/completions")
     for i := 0; i < maxRetries; i++ {
-        response, inputTokens, outputTokens, isAPITokenCount, err := callOpenAI(history)
+        if err := cb.Allow(); err != nil {
+            logEvent("OpenAICircuitOpen", map[string]interface{}{
+                "attempt": i + 1,
+                "error":   err.Error(),
+            })
+            return CompletionResult{}, err
+        }
+        result, err := callOpenAI(req)
+        cb.Record(err)
         if err == nil {
-            return response, inputTokens, outputTokens, isAPITokenCount, nil
+            return result, nil
         }
         lastErr = err
         logEvent("OpenAIRetry", map[string]interface{}{
@@ -428,48 +786,165 @@ func callOpenAIWithRetry(history []Message) (string, int, int, bool, error) {
         })
         time.Sleep(retryDelay)
     }
-    return "", 0, 0, false, fmt.Errorf("All attempts failed. Last error: %v", lastErr)
+    return CompletionResult{}, fmt.Errorf("All attempts failed. Last error: %v", lastErr)
+}
+
+// callOpenAI 发起对话补全；启用工具时会在模型请求工具调用后执行工具并继续请求，直到得到最终回答
+func callOpenAI(req completionRequest) (CompletionResult, error) {
+    var result CompletionResult
+    result.IsAPITokenCount = true
+    result.Model = req.Model
+    if result.Model == "" {
+        result.Model = currentModel
+    }
+    if result.Model == autoModelID {
+        result.Model, result.RouteReason = routeModel(req)
+        logEvent("ModelRouted", map[string]interface{}{
+            "model":  result.Model,
+            "reason": result.RouteReason,
+        })
+        routed, ok := req.Role.resolveModel(result.Model)
+        if !ok {
+            return CompletionResult{}, fmt.Errorf("自动路由选择的模型 %s 不在角色 %s 允许的范围内", result.Model, req.Role.label())
+        }
+        if routed != result.Model {
+            result.Model = routed
+            result.RouteReason += "，角色不可用，改用 " + routed
+        }
+    }
+
+    messages := append([]Message(nil), req.Messages...)
+    for step := 0; ; step++ {
+        var tools []ToolDefinition
+        if !req.NoTools && step < toolMaxSteps() && modelSupports(result.Model, "tools", false) {
+            tools = toolDefinitions(req.ChatID)
+        }
+
+        openAIResp, err := postChatCompletion(result.Model, req.Params, messages, tools)
+        if err != nil {
+            return CompletionResult{}, err
+        }
+        if len(openAIResp.Choices) == 0 {
+            logEvent("NoChoicesInResponseError", nil)
+            return CompletionResult{}, fmt.Errorf("No response from AI")
+        }
+        choice := openAIResp.Choices[0]
+        reasoning, content := splitReasoning(choice)
+        result.addReasoning(reasoning)
+
+        if openAIResp.Usage != nil && openAIResp.Usage.PromptTokens > 0 && openAIResp.Usage.CompletionTokens > 0 {
+            result.InputTokens += openAIResp.Usage.PromptTokens
+            result.OutputTokens += openAIResp.Usage.CompletionTokens
+            if details := openAIResp.Usage.CompletionTokensDetails; details != nil && details.ReasoningTokens > 0 {
+                result.ReasoningTokens += details.ReasoningTokens
+            } else {
+                result.ReasoningTokens += calculateTokens(reasoning)
+            }
+        } else {
+            result.InputTokens += calculateTokens(messages)
+            result.OutputTokens += calculateTokens(content) + calculateTokens(reasoning)
+            result.ReasoningTokens += calculateTokens(reasoning)
+            result.IsAPITokenCount = false
+        }
+
+        if len(choice.Message.ToolCalls) > 0 && len(tools) > 0 {
+            messages = append(messages, Message{
+                Role:      "assistant",
+                Content:   content,
+                ToolCalls: choice.Message.ToolCalls,
+                Time:      time.Now(),
+            })
+            for _, call := range choice.Message.ToolCalls {
+                output, note := executeToolCall(req.ChatID, call)
+                result.ToolNotes = append(result.ToolNotes, note)
+                messages = append(messages, Message{
+                    Role:       "tool",
+                    Content:    output,
+                    ToolCallID: call.ID,
+                    Time:       time.Now(),
+                })
+            }
+            continue
+        }
+
+        result.Content = content
+        return result, nil
+    }
+}
+
+// apiMessages 去掉仅供本地使用的字段
+func apiMessages(messages []Message) []Message {
+    out := make([]Message, len(messages))
+    for i, msg := range messages {
+        msg.TgMsgID = 0
+        out[i] = msg
+    }
+    return out
 }
 
-func callOpenAI(history []Message) (string, int, int, bool, error) {
+func postChatCompletion(model string, params GenerationParams, messages []Message, tools []ToolDefinition) (*OpenAIResponse, error) {
     logEvent("OpenAIRequest", map[string]interface{}{
-        "history": history,
+        "model":   model,
+        "history": messages,
+        "tools":   len(tools),
     })
 
     requestBody := OpenAIRequest{
-        Model:    currentModel,
-        Messages: history,
+        Model:            model,
+        Messages:         apiMessages(messages),
+        Tools:            tools,
+        GenerationParams: params.forModel(model),
+    }
+    if config.OpenAIConfig.Stream {
+        requestBody.Stream = true
+        requestBody.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
     }
 
     jsonBody, err := json.Marshal(requestBody)
     if err != nil {
         logEvent("MarshalRequestError", err)
-        return "", 0, 0, false, fmt.Errorf("Error processing request")
+        return nil, fmt.Errorf("Error processing request")
     }
 
     req, err := http.NewRequest("POST", config.OpenAIConfig.APIURL+"/chat/completions", bytes.NewBuffer(jsonBody))
     if err != nil {
         logEvent("CreateRequestError", err)
-        return "", 0, 0, false, fmt.Errorf("Error processing request")
+        return nil, fmt.Errorf("Error processing request")
     }
 
     req.Header.Set("Content-Type", "application/json")
     req.Header.Set("Authorization", "Bearer "+config.OpenAIConfig.APIKey)
 
+    // 流式响应边生成边返回，推理模型耗时较长，放宽整体超时
+    timeout := 60 * time.Second
+    if requestBody.Stream {
+        timeout = 5 * time.Minute
+    }
     client := &http.Client{
-        Timeout: 60 * time.Second,
+        Timeout: timeout,
     }
     resp, err := client.Do(req)
     if err != nil {
         logEvent("SendRequestError", err)
-        return "", 0, 0, false, fmt.Errorf("Error processing request")
+        return nil, &upstreamError{msg: "Error processing request"}
     }
     defer resp.Body.Close()
 
+    if resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+        return readChatCompletionStream(resp.Body)
+    }
+
     body, err := ioutil.ReadAll(resp.Body)
     if err != nil {
         logEvent("ReadResponseBodyError", err)
-        return "", 0, 0, false, fmt.Errorf("Error processing response")
+        return nil, &upstreamError{msg: "Error processing response"}
+    }
+    if resp.StatusCode >= 500 {
+        logEvent("UpstreamServerError", map[string]interface{}{
+            "status": resp.StatusCode,
+            "body":   string(body),
+        })
+        return nil, &upstreamError{msg: fmt.Sprintf("Upstream error: HTTP %d", resp.StatusCode)}
     }
 
     var openAIResp OpenAIResponse
@@ -477,34 +952,12 @@ func callOpenAI(history []Message) (string, int, int, bool, error) {
     if err != nil {
         var errorResp OpenAIErrorResponse
         if json.Unmarshal(body, &errorResp) == nil {
-            return "", 0, 0, false, fmt.Errorf("API Error: %s - %s", errorResp.Error.Type, errorResp.Error.Message)
+            return nil, fmt.Errorf("API Error: %s - %s", errorResp.Error.Type, errorResp.Error.Message)
         }
         logEvent("UnmarshalResponseError", err)
-        return "", 0, 0, false, fmt.Errorf("Error processing response")
-    }
-
-    if len(openAIResp.Choices) > 0 {
-        response := openAIResp.Choices[0].Message.Content
-        conversationHistory = append(conversationHistory, Message{Role: "assistant", Content: response, Time: time.Now()})
-
-        var inputTokens, outputTokens int
-        var isAPITokenCount bool
-
-        if openAIResp.Usage != nil && openAIResp.Usage.PromptTokens > 0 && openAIResp.Usage.CompletionTokens > 0 {
-            inputTokens = openAIResp.Usage.PromptTokens
-            outputTokens = openAIResp.Usage.CompletionTokens
-            isAPITokenCount = true
-        } else {
-            inputTokens = calculateTokens(history)
-            outputTokens = calculateTokens(response)
-            isAPITokenCount = false
-        }
-
-        return response, inputTokens, outputTokens, isAPITokenCount, nil
+        return nil, fmt.Errorf("Error processing response")
     }
-
-    logEvent("NoChoicesInResponseError", nil)
-    return "", 0, 0, false, fmt.Errorf("No response from AI")
+    return &openAIResp, nil
 }
 
 func calculateTokens(history interface{}) int {
@@ -530,6 +983,51 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
         "query": query,
     })
 
+    if strings.HasPrefix(query.Data, "mcp:") {
+        handleMCPToggle(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "session:") {
+        handleSessionSwitch(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "persona:") {
+        handlePersonaSelect(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "params:") {
+        handleParamsCallback(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "reasoning:") {
+        handleReasoningCallback(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "cmp:") {
+        handleCompareCallback(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "mdl:") {
+        handleModelPickerCallback(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "mfav:") {
+        handleFavoriteCallback(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "edit:") {
+        handleEditBranchCallback(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "acc:") {
+        handleAccessRequestCallback(bot, query)
+        return
+    }
+    if strings.HasPrefix(query.Data, "chcap:") {
+        handleCaptionDraftCallback(bot, query)
+        return
+    }
+
     if !strings.HasPrefix(query.Data, "model:") {
         logEvent("UnexpectedCallbackData", map[string]interface{}{
             "data": query.Data,
@@ -537,15 +1035,37 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
         return
     }
 
-    newModel := strings.TrimPrefix(query.Data, "model:")
+    newModel, ok := resolveModelToken(strings.TrimPrefix(query.Data, "model:"))
+    if !ok {
+        bot.Request(tgbotapi.NewCallback(query.ID, "列表已过期，请重新发送 /models"))
+        return
+    }
     logEvent("ModelChangeRequested", map[string]interface{}{
         "model": newModel,
     })
+    if role := userRole(query.From.ID); !role.allowsModel(newModel) {
+        bot.Request(tgbotapi.NewCallback(query.ID, fmt.Sprintf("你的角色（%s）不能使用 %s", role.label(), newModel)))
+        return
+    }
 
-    currentModel = newModel
+    key := callbackSessionKey(query)
+    withActiveSession(key, func(sess *Session) {
+        sess.Model = newModel
+    })
+    // 论坛话题中选择的模型同时作为该话题新会话的默认模型
+    if threadID := keyThreadID(key); threadID != 0 {
+        updateTopicSettings(query.Message.Chat.ID, threadID, func(topic *TopicSettings) {
+            topic.Model = newModel
+        })
+    }
 
-    confirmMsg := tgbotapi.NewMessage(query.Message.Chat.ID, fmt.Sprintf("模型已更新为：%s", currentModel))
-    sentMsg, err := bot.Send(confirmMsg)
+    confirmText := fmt.Sprintf("模型已更新为：%s", newModel)
+    if summary := capabilitySummary(newModel); summary != "" {
+        confirmText += "\n" + summary
+    }
+    confirmMsg := tgbotapi.NewMessage(query.Message.Chat.ID, confirmText)
+    confirmMsg.ReplyMarkup = favoriteButton(query.Message.Chat.ID, newModel)
+    sentMsg, err := sendThreaded(bot, confirmMsg, keyThreadID(key))
     if err != nil {
         logEvent("SendConfirmMessageError", err)
     } else {
@@ -564,7 +1084,7 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
         })
     }
 
-    callback := tgbotapi.NewCallback(query.ID, fmt.Sprintf("模型已更新为 %s", currentModel))
+    callback := tgbotapi.NewCallback(query.ID, fmt.Sprintf("模型已更新为 %s", newModel))
     resp, err = bot.Request(callback)
     if err != nil {
         logEvent("AnswerCallbackQueryError", err)
@@ -574,26 +1094,42 @@ func handleCallbackQuery(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
         })
     }
 
-    sendInitInfo(bot, query.Message.Chat.ID)
+    sendInitInfo(bot, query.Message.Chat.ID, key)
 }
 
-func formatResponse(response string, inputTokens, outputTokens int, isAPITokenCount bool, duration time.Duration, remainingRounds, remainingMinutes, remainingSeconds int) string {
-    formattedResponse := mdToTgmd(response)
+func formatResponse(result CompletionResult, duration time.Duration, remainingRounds, remainingMinutes, remainingSeconds int, showReasoning bool) string {
+    formattedResponse := formatToolNotes(result.ToolNotes) + mdToTgmd(result.Content)
+    if showReasoning {
+        formattedResponse = formatReasoning(result.Reasoning) + formattedResponse
+    }
 
     tokenSource := "API值"
-    if !isAPITokenCount {
+    if !result.IsAPITokenCount {
         tokenSource = "估算"
     }
 
+    reasoningStats := ""
+    if result.ReasoningTokens > 0 {
+        reasoningStats = fmt.Sprintf("💭 推理: %d (%s)\n", result.ReasoningTokens, tokenSource)
+    }
+
+    routeStats := ""
+    if result.RouteReason != "" {
+        routeStats = fmt.Sprintf("🧭 自动路由: %s\n", result.RouteReason)
+    }
+
+    totalInput, totalOutput := tokenTotals()
     stats := fmt.Sprintf("\n\n━━━━━━ 统计信息 ━━━━━━\n"+
         "📊 输入: %d (%s)    总输入: %d\n"+
         "📈 输出: %d (%s)    总输出: %d\n"+
+        "%s"+
         "⏱ 处理时间: %.2f秒\n"+
         "🔄 剩余对话轮数: %d\n"+
         "🕒 剩余有效时间: %d分钟 %d秒\n"+
         "🤖 当前使用模型: %s\n"+
+        "%s"+
         "━━━━━━━━━━━━━━━━━",
-        inputTokens, tokenSource, totalInputTokens, outputTokens, tokenSource, totalOutputTokens, duration.Seconds(), remainingRounds, remainingMinutes, remainingSeconds, currentModel)
+        result.InputTokens, tokenSource, totalInput, result.OutputTokens, tokenSource, totalOutput, reasoningStats, duration.Seconds(), remainingRounds, remainingMinutes, remainingSeconds, result.Model, routeStats)
     
     formattedResponse += mdToTgmd(stats)
 
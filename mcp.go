@@ -0,0 +1,948 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "os/exec"
+    "regexp"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MCP（Model Context Protocol）客户端，支持 stdio 与 streamable HTTP 两种传输方式
+
+const mcpProtocolVersion = "2025-03-26"
+
+// 连接失败后的重试间隔，每次翻倍直到上限
+const (
+    mcpRetryInitial = 5 * time.Second
+    mcpRetryMax     = 5 * time.Minute
+)
+
+type MCPServerConfig struct {
+    Name           string            `yaml:"name"`
+    Transport      string            `yaml:"transport"` // stdio 或 http
+    Command        string            `yaml:"command"`
+    Args           []string          `yaml:"args"`
+    Env            map[string]string `yaml:"env"`
+    URL            string            `yaml:"url"`
+    Headers        map[string]string `yaml:"headers"`
+    AllowedTools   []string          `yaml:"allowed_tools"`
+    AllowResources bool              `yaml:"allow_resources"`
+    AllowedChats   []int64           `yaml:"allowed_chats"`
+    DefaultEnabled bool              `yaml:"default_enabled"`
+    TimeoutSeconds int               `yaml:"timeout_seconds"`
+}
+
+type jsonRPCRequest struct {
+    JSONRPC string      `json:"jsonrpc"`
+    ID      *int64      `json:"id,omitempty"`
+    Method  string      `json:"method"`
+    Params  interface{} `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+    JSONRPC string          `json:"jsonrpc"`
+    ID      *int64          `json:"id"`
+    Method  string          `json:"method"`
+    Result  json.RawMessage `json:"result"`
+    Error   *struct {
+        Code    int    `json:"code"`
+        Message string `json:"message"`
+    } `json:"error"`
+}
+
+type mcpTransport interface {
+    call(ctx context.Context, req jsonRPCRequest) (*jsonRPCResponse, error)
+    notify(ctx context.Context, req jsonRPCRequest) error
+    close() error
+}
+
+type mcpToolInfo struct {
+    Name        string                 `json:"name"`
+    Description string                 `json:"description"`
+    InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+type mcpResourceInfo struct {
+    URI         string `json:"uri"`
+    Name        string `json:"name"`
+    Description string `json:"description"`
+    MimeType    string `json:"mimeType"`
+}
+
+type mcpClient struct {
+    cfg       MCPServerConfig
+    connectMu sync.Mutex // 同一时间只进行一次连接
+    mu        sync.Mutex // 只保护下面的字段，不在网络 I/O 期间持有
+    transport mcpTransport
+    nextID    int64
+    tools     []mcpToolInfo
+    resources []mcpResourceInfo
+    lastError string
+}
+
+var (
+    mcpMu      sync.RWMutex
+    mcpClients = map[string]*mcpClient{}
+)
+
+// startMCPServers 连接所有配置的 MCP 服务器并注册它们的工具
+func startMCPServers() {
+    for _, cfg := range config.MCPServers {
+        if cfg.Name == "" {
+            logEvent("MCPConfigError", "mcp server without name")
+            continue
+        }
+        client := &mcpClient{cfg: cfg}
+        mcpMu.Lock()
+        mcpClients[cfg.Name] = client
+        mcpMu.Unlock()
+
+        go client.connectWithRetry()
+    }
+}
+
+func getMCPClient(name string) *mcpClient {
+    mcpMu.RLock()
+    defer mcpMu.RUnlock()
+    return mcpClients[name]
+}
+
+func mcpServerNames() []string {
+    names := make([]string, 0, len(config.MCPServers))
+    for _, cfg := range config.MCPServers {
+        if getMCPClient(cfg.Name) != nil {
+            names = append(names, cfg.Name)
+        }
+    }
+    return names
+}
+
+func (c *mcpClient) timeout() time.Duration {
+    if c.cfg.TimeoutSeconds > 0 {
+        return time.Duration(c.cfg.TimeoutSeconds) * time.Second
+    }
+    return 30 * time.Second
+}
+
+// connect 建立新连接并完成初始化，成功后替换旧连接并重新注册工具
+func (c *mcpClient) connect() error {
+    c.connectMu.Lock()
+    defer c.connectMu.Unlock()
+    return c.dialLocked()
+}
+
+// reconnect 在 failed 连接出错后重连；其他请求已经换上新连接时直接返回
+func (c *mcpClient) reconnect(failed mcpTransport) error {
+    c.connectMu.Lock()
+    defer c.connectMu.Unlock()
+    c.mu.Lock()
+    current := c.transport
+    c.mu.Unlock()
+    if current != nil && current != failed {
+        return nil
+    }
+    return c.dialLocked()
+}
+
+// connectWithRetry 连接服务器，失败时按指数退避一直重试
+func (c *mcpClient) connectWithRetry() {
+    delay := mcpRetryInitial
+    for {
+        err := c.connect()
+        if err == nil {
+            return
+        }
+        logEvent("MCPConnectError", map[string]interface{}{
+            "server":  c.cfg.Name,
+            "error":   err.Error(),
+            "retryIn": delay.String(),
+        })
+        time.Sleep(delay)
+        delay *= 2
+        if delay > mcpRetryMax {
+            delay = mcpRetryMax
+        }
+    }
+}
+
+func (c *mcpClient) setError(err error) {
+    c.mu.Lock()
+    c.lastError = err.Error()
+    c.mu.Unlock()
+}
+
+// dialLocked 完成连接和初始化，调用方需持有 c.connectMu；网络 I/O 期间不持有 c.mu，避免阻塞其他请求和 /mcp
+func (c *mcpClient) dialLocked() error {
+    c.mu.Lock()
+    old := c.transport
+    c.transport = nil
+    c.mu.Unlock()
+    if old != nil {
+        old.close()
+    }
+
+    var transport mcpTransport
+    var err error
+    switch c.cfg.Transport {
+    case "stdio", "":
+        transport, err = newStdioTransport(c.cfg)
+    case "http", "streamable-http", "streamable_http":
+        transport = newHTTPTransport(c.cfg)
+    default:
+        err = fmt.Errorf("unsupported transport %q", c.cfg.Transport)
+    }
+    if err != nil {
+        c.setError(err)
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+    defer cancel()
+
+    var initResult struct {
+        ProtocolVersion string `json:"protocolVersion"`
+        Capabilities    struct {
+            Tools     *json.RawMessage `json:"tools"`
+            Resources *json.RawMessage `json:"resources"`
+        } `json:"capabilities"`
+        ServerInfo struct {
+            Name    string `json:"name"`
+            Version string `json:"version"`
+        } `json:"serverInfo"`
+    }
+    fail := func(err error) error {
+        transport.close()
+        c.setError(err)
+        return err
+    }
+    err = c.call(ctx, transport, "initialize", map[string]interface{}{
+        "protocolVersion": mcpProtocolVersion,
+        "capabilities":    map[string]interface{}{},
+        "clientInfo": map[string]interface{}{
+            "name":    "fyaitg",
+            "version": version,
+        },
+    }, &initResult)
+    if err != nil {
+        return fail(fmt.Errorf("initialize: %v", err))
+    }
+    if ht, ok := transport.(*mcpHTTPTransport); ok {
+        ht.protocolVersion = initResult.ProtocolVersion
+    }
+    if err := transport.notify(ctx, jsonRPCRequest{JSONRPC: "2.0", Method: "notifications/initialized"}); err != nil {
+        return fail(fmt.Errorf("initialized notification: %v", err))
+    }
+
+    var tools []mcpToolInfo
+    if initResult.Capabilities.Tools != nil {
+        cursor := ""
+        for {
+            var page struct {
+                Tools      []mcpToolInfo `json:"tools"`
+                NextCursor string        `json:"nextCursor"`
+            }
+            params := map[string]interface{}{}
+            if cursor != "" {
+                params["cursor"] = cursor
+            }
+            if err := c.call(ctx, transport, "tools/list", params, &page); err != nil {
+                return fail(fmt.Errorf("tools/list: %v", err))
+            }
+            tools = append(tools, page.Tools...)
+            if page.NextCursor == "" {
+                break
+            }
+            cursor = page.NextCursor
+        }
+    }
+
+    var resources []mcpResourceInfo
+    if initResult.Capabilities.Resources != nil && c.cfg.AllowResources {
+        var page struct {
+            Resources []mcpResourceInfo `json:"resources"`
+        }
+        if err := c.call(ctx, transport, "resources/list", map[string]interface{}{}, &page); err != nil {
+            logEvent("MCPListResourcesError", map[string]interface{}{
+                "server": c.cfg.Name,
+                "error":  err.Error(),
+            })
+        } else {
+            resources = page.Resources
+        }
+    }
+
+    c.mu.Lock()
+    c.transport, c.tools, c.resources, c.lastError = transport, tools, resources, ""
+    c.mu.Unlock()
+    logEvent("MCPConnected", map[string]interface{}{
+        "server":    c.cfg.Name,
+        "info":      initResult.ServerInfo,
+        "protocol":  initResult.ProtocolVersion,
+        "tools":     len(tools),
+        "resources": len(resources),
+    })
+    c.registerTools()
+    return nil
+}
+
+var errMCPNotConnected = &mcpTransportError{err: errors.New("not connected")}
+
+// call 通过指定连接发送 JSON-RPC 请求并解析结果
+func (c *mcpClient) call(ctx context.Context, transport mcpTransport, method string, params interface{}, out interface{}) error {
+    if transport == nil {
+        return errMCPNotConnected
+    }
+    id := atomic.AddInt64(&c.nextID, 1)
+    resp, err := transport.call(ctx, jsonRPCRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+    if err != nil {
+        return err
+    }
+    if resp.Error != nil {
+        return fmt.Errorf("%s (code %d)", resp.Error.Message, resp.Error.Code)
+    }
+    if out != nil && len(resp.Result) > 0 {
+        return json.Unmarshal(resp.Result, out)
+    }
+    return nil
+}
+
+func (c *mcpClient) currentTransport() mcpTransport {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.transport
+}
+
+// request 发送请求，连接断开时重连一次
+func (c *mcpClient) request(method string, params interface{}, out interface{}) error {
+    ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+    defer cancel()
+
+    transport := c.currentTransport()
+    err := c.call(ctx, transport, method, params, out)
+    if err == nil || !isMCPTransportError(err) {
+        return err
+    }
+
+    logEvent("MCPReconnect", map[string]interface{}{
+        "server": c.cfg.Name,
+        "error":  err.Error(),
+    })
+    if cerr := c.reconnect(transport); cerr != nil {
+        return cerr
+    }
+    ctx2, cancel2 := context.WithTimeout(context.Background(), c.timeout())
+    defer cancel2()
+    return c.call(ctx2, c.currentTransport(), method, params, out)
+}
+
+type mcpTransportError struct {
+    err error
+}
+
+func (e *mcpTransportError) Error() string {
+    return "transport: " + e.err.Error()
+}
+
+func isMCPTransportError(err error) bool {
+    var te *mcpTransportError
+    return errors.As(err, &te)
+}
+
+func (c *mcpClient) toolAllowed(name string) bool {
+    for _, allowed := range c.cfg.AllowedTools {
+        if allowed == "*" || allowed == name {
+            return true
+        }
+    }
+    return false
+}
+
+func (c *mcpClient) chatAllowed(chatID int64) bool {
+    if len(c.cfg.AllowedChats) == 0 {
+        return true
+    }
+    for _, id := range c.cfg.AllowedChats {
+        if id == chatID {
+            return true
+        }
+    }
+    return false
+}
+
+// enabledForChat 判断该服务器在聊天中是否启用：管理员白名单优先，其次是聊天中的开关，最后是默认值
+func (c *mcpClient) enabledForChat(chatID int64) bool {
+    if !c.chatAllowed(chatID) {
+        return false
+    }
+    enabled := c.cfg.DefaultEnabled
+    readChatSettings(chatID, func(settings *ChatSettings) {
+        if v, ok := settings.MCPServers[c.cfg.Name]; ok {
+            enabled = v
+        }
+    })
+    return enabled
+}
+
+var mcpToolNameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+func mcpToolName(server, tool string) string {
+    name := "mcp_" + mcpToolNameSanitizer.ReplaceAllString(server, "_") + "_" + mcpToolNameSanitizer.ReplaceAllString(tool, "_")
+    if len(name) > 64 {
+        name = name[:64]
+    }
+    return name
+}
+
+// registerTools 将服务器的工具（按白名单过滤）注册到工具注册表，每次连接成功后重新注册
+func (c *mcpClient) registerTools() {
+    c.mu.Lock()
+    tools := append([]mcpToolInfo(nil), c.tools...)
+    resources := append([]mcpResourceInfo(nil), c.resources...)
+    c.mu.Unlock()
+
+    unregisterServerTools(c.cfg.Name)
+
+    registered := 0
+    for _, info := range tools {
+        if !c.toolAllowed(info.Name) {
+            continue
+        }
+        info := info
+        schema := info.InputSchema
+        if schema == nil {
+            schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+        }
+        registerTool(&Tool{
+            Name:        mcpToolName(c.cfg.Name, info.Name),
+            Description: fmt.Sprintf("[%s] %s", c.cfg.Name, info.Description),
+            Parameters:  schema,
+            Server:      c.cfg.Name,
+            Handler: func(args json.RawMessage) (string, error) {
+                return c.callTool(info.Name, args)
+            },
+        })
+        registered++
+    }
+
+    if len(resources) > 0 {
+        uris := make([]string, 0, len(resources))
+        var lines []string
+        for i, r := range resources {
+            uris = append(uris, r.URI)
+            if i < 30 {
+                lines = append(lines, fmt.Sprintf("- %s: %s %s", r.URI, r.Name, r.Description))
+            }
+        }
+        registerTool(&Tool{
+            Name:        mcpToolName(c.cfg.Name, "read_resource"),
+            Description: fmt.Sprintf("[%s] Read a resource from the %s MCP server. Available resources:\n%s", c.cfg.Name, c.cfg.Name, strings.Join(lines, "\n")),
+            Parameters: map[string]interface{}{
+                "type": "object",
+                "properties": map[string]interface{}{
+                    "uri": map[string]interface{}{"type": "string", "enum": uris},
+                },
+                "required": []string{"uri"},
+            },
+            Server:  c.cfg.Name,
+            Handler: c.readResource,
+        })
+        registered++
+    }
+
+    logEvent("MCPToolsRegistered", map[string]interface{}{
+        "server": c.cfg.Name,
+        "count":  registered,
+    })
+}
+
+func (c *mcpClient) callTool(name string, args json.RawMessage) (string, error) {
+    if !c.toolAllowed(name) {
+        return "", fmt.Errorf("tool %s is not allowed on server %s", name, c.cfg.Name)
+    }
+    var arguments map[string]interface{}
+    if err := json.Unmarshal(args, &arguments); err != nil {
+        return "", fmt.Errorf("invalid arguments: %v", err)
+    }
+    var result struct {
+        Content []struct {
+            Type     string `json:"type"`
+            Text     string `json:"text"`
+            MimeType string `json:"mimeType"`
+            Resource *struct {
+                URI  string `json:"uri"`
+                Text string `json:"text"`
+            } `json:"resource"`
+        } `json:"content"`
+        IsError bool `json:"isError"`
+    }
+    if err := c.request("tools/call", map[string]interface{}{"name": name, "arguments": arguments}, &result); err != nil {
+        return "", err
+    }
+
+    var parts []string
+    for _, item := range result.Content {
+        switch item.Type {
+        case "text":
+            parts = append(parts, item.Text)
+        case "resource":
+            if item.Resource != nil {
+                parts = append(parts, item.Resource.Text)
+            }
+        default:
+            parts = append(parts, fmt.Sprintf("[%s content %s omitted]", item.Type, item.MimeType))
+        }
+    }
+    output := strings.Join(parts, "\n")
+    if result.IsError {
+        return "", fmt.Errorf("%s", output)
+    }
+    return output, nil
+}
+
+func (c *mcpClient) readResource(args json.RawMessage) (string, error) {
+    var params struct {
+        URI string `json:"uri"`
+    }
+    if err := json.Unmarshal(args, &params); err != nil {
+        return "", fmt.Errorf("invalid arguments: %v", err)
+    }
+    known := false
+    c.mu.Lock()
+    for _, r := range c.resources {
+        if r.URI == params.URI {
+            known = true
+            break
+        }
+    }
+    c.mu.Unlock()
+    if !known {
+        return "", fmt.Errorf("unknown resource %q", params.URI)
+    }
+
+    var result struct {
+        Contents []struct {
+            URI      string `json:"uri"`
+            MimeType string `json:"mimeType"`
+            Text     string `json:"text"`
+            Blob     string `json:"blob"`
+        } `json:"contents"`
+    }
+    if err := c.request("resources/read", map[string]interface{}{"uri": params.URI}, &result); err != nil {
+        return "", err
+    }
+    var parts []string
+    for _, content := range result.Contents {
+        if content.Text != "" {
+            parts = append(parts, content.Text)
+        } else if content.Blob != "" {
+            parts = append(parts, fmt.Sprintf("[binary %s omitted]", content.MimeType))
+        }
+    }
+    return strings.Join(parts, "\n"), nil
+}
+
+func (c *mcpClient) status() (connected bool, tools int, lastError string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    for _, t := range c.tools {
+        if c.toolAllowed(t.Name) {
+            tools++
+        }
+    }
+    return c.transport != nil && c.lastError == "", tools, c.lastError
+}
+
+// stdio 传输：子进程的 stdin/stdout 上按行收发 JSON-RPC 消息
+type mcpStdioTransport struct {
+    cmd     *exec.Cmd
+    stdin   io.WriteCloser
+    writeMu sync.Mutex
+    mu      sync.Mutex
+    pending map[int64]chan *jsonRPCResponse
+    closed  bool
+    err     error
+}
+
+func newStdioTransport(cfg MCPServerConfig) (*mcpStdioTransport, error) {
+    if cfg.Command == "" {
+        return nil, fmt.Errorf("stdio transport requires command")
+    }
+    cmd := exec.Command(cfg.Command, cfg.Args...)
+    cmd.Env = os.Environ()
+    for k, v := range cfg.Env {
+        cmd.Env = append(cmd.Env, k+"="+v)
+    }
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return nil, err
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    stderr, err := cmd.StderrPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err := cmd.Start(); err != nil {
+        return nil, err
+    }
+
+    t := &mcpStdioTransport{cmd: cmd, stdin: stdin, pending: map[int64]chan *jsonRPCResponse{}}
+    go t.readLoop(stdout)
+    go func() {
+        scanner := bufio.NewScanner(stderr)
+        for scanner.Scan() {
+            logEvent("MCPServerStderr", map[string]interface{}{
+                "server": cfg.Name,
+                "line":   scanner.Text(),
+            })
+        }
+    }()
+    return t, nil
+}
+
+func (t *mcpStdioTransport) readLoop(stdout io.Reader) {
+    reader := bufio.NewReader(stdout)
+    for {
+        line, err := reader.ReadBytes('\n')
+        if len(bytes.TrimSpace(line)) > 0 {
+            var resp jsonRPCResponse
+            if jerr := json.Unmarshal(line, &resp); jerr == nil && resp.ID != nil && resp.Method == "" {
+                t.mu.Lock()
+                ch, ok := t.pending[*resp.ID]
+                delete(t.pending, *resp.ID)
+                t.mu.Unlock()
+                if ok {
+                    ch <- &resp
+                }
+            }
+        }
+        if err != nil {
+            t.fail(err)
+            return
+        }
+    }
+}
+
+func (t *mcpStdioTransport) fail(err error) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.closed {
+        return
+    }
+    t.closed = true
+    t.err = err
+    for id, ch := range t.pending {
+        close(ch)
+        delete(t.pending, id)
+    }
+}
+
+func (t *mcpStdioTransport) write(req jsonRPCRequest) error {
+    data, err := json.Marshal(req)
+    if err != nil {
+        return err
+    }
+    t.writeMu.Lock()
+    defer t.writeMu.Unlock()
+    if _, err := t.stdin.Write(append(data, '\n')); err != nil {
+        return &mcpTransportError{err: err}
+    }
+    return nil
+}
+
+func (t *mcpStdioTransport) call(ctx context.Context, req jsonRPCRequest) (*jsonRPCResponse, error) {
+    ch := make(chan *jsonRPCResponse, 1)
+    t.mu.Lock()
+    if t.closed {
+        t.mu.Unlock()
+        return nil, &mcpTransportError{err: fmt.Errorf("process exited: %v", t.err)}
+    }
+    t.pending[*req.ID] = ch
+    t.mu.Unlock()
+
+    if err := t.write(req); err != nil {
+        t.mu.Lock()
+        delete(t.pending, *req.ID)
+        t.mu.Unlock()
+        return nil, err
+    }
+
+    select {
+    case resp, ok := <-ch:
+        if !ok {
+            return nil, &mcpTransportError{err: fmt.Errorf("process exited")}
+        }
+        return resp, nil
+    case <-ctx.Done():
+        t.mu.Lock()
+        delete(t.pending, *req.ID)
+        t.mu.Unlock()
+        return nil, ctx.Err()
+    }
+}
+
+func (t *mcpStdioTransport) notify(ctx context.Context, req jsonRPCRequest) error {
+    return t.write(req)
+}
+
+func (t *mcpStdioTransport) close() error {
+    t.fail(fmt.Errorf("closed"))
+    t.stdin.Close()
+    if t.cmd.Process != nil {
+        t.cmd.Process.Kill()
+    }
+    return t.cmd.Wait()
+}
+
+// streamable HTTP 传输：每个请求一个 POST，响应可能是 JSON 或 SSE 流
+type mcpHTTPTransport struct {
+    url             string
+    headers         map[string]string
+    client          *http.Client
+    mu              sync.Mutex
+    sessionID       string
+    protocolVersion string
+}
+
+func newHTTPTransport(cfg MCPServerConfig) *mcpHTTPTransport {
+    return &mcpHTTPTransport{
+        url:     cfg.URL,
+        headers: cfg.Headers,
+        client:  &http.Client{},
+    }
+}
+
+func (t *mcpHTTPTransport) post(ctx context.Context, req jsonRPCRequest) (*http.Response, error) {
+    body, err := json.Marshal(req)
+    if err != nil {
+        return nil, err
+    }
+    httpReq, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    httpReq.Header.Set("Content-Type", "application/json")
+    httpReq.Header.Set("Accept", "application/json, text/event-stream")
+    for k, v := range t.headers {
+        httpReq.Header.Set(k, v)
+    }
+    t.mu.Lock()
+    if t.sessionID != "" {
+        httpReq.Header.Set("Mcp-Session-Id", t.sessionID)
+    }
+    if t.protocolVersion != "" {
+        httpReq.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+    }
+    t.mu.Unlock()
+
+    resp, err := t.client.Do(httpReq)
+    if err != nil {
+        return nil, &mcpTransportError{err: err}
+    }
+    if sid := resp.Header.Get("Mcp-Session-Id"); sid != "" {
+        t.mu.Lock()
+        t.sessionID = sid
+        t.mu.Unlock()
+    }
+    if resp.StatusCode == http.StatusNotFound && t.sessionID != "" {
+        // 会话已失效，需要重新初始化
+        resp.Body.Close()
+        return nil, &mcpTransportError{err: fmt.Errorf("session expired")}
+    }
+    if resp.StatusCode >= 400 {
+        data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+        resp.Body.Close()
+        return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+    }
+    return resp, nil
+}
+
+func (t *mcpHTTPTransport) call(ctx context.Context, req jsonRPCRequest) (*jsonRPCResponse, error) {
+    resp, err := t.post(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+        return readSSEResponse(resp.Body, *req.ID)
+    }
+    var rpcResp jsonRPCResponse
+    if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+        return nil, fmt.Errorf("decode response: %v", err)
+    }
+    return &rpcResp, nil
+}
+
+// readSSEResponse 从 SSE 流中读取与请求 ID 对应的响应，忽略服务器推送的其他消息
+func readSSEResponse(body io.Reader, id int64) (*jsonRPCResponse, error) {
+    reader := bufio.NewReader(body)
+    var data strings.Builder
+    for {
+        line, err := reader.ReadString('\n')
+        trimmed := strings.TrimRight(line, "\r\n")
+        if strings.HasPrefix(trimmed, "data:") {
+            data.WriteString(strings.TrimPrefix(strings.TrimPrefix(trimmed, "data:"), " "))
+        } else if trimmed == "" && data.Len() > 0 {
+            var resp jsonRPCResponse
+            if jerr := json.Unmarshal([]byte(data.String()), &resp); jerr == nil && resp.ID != nil && *resp.ID == id && resp.Method == "" {
+                return &resp, nil
+            }
+            data.Reset()
+        }
+        if err != nil {
+            if err == io.EOF {
+                return nil, &mcpTransportError{err: fmt.Errorf("stream ended without response")}
+            }
+            return nil, &mcpTransportError{err: err}
+        }
+    }
+}
+
+func (t *mcpHTTPTransport) notify(ctx context.Context, req jsonRPCRequest) error {
+    resp, err := t.post(ctx, req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+func (t *mcpHTTPTransport) close() error {
+    t.mu.Lock()
+    sessionID := t.sessionID
+    t.sessionID = ""
+    t.mu.Unlock()
+    if sessionID == "" {
+        return nil
+    }
+    req, err := http.NewRequest("DELETE", t.url, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Mcp-Session-Id", sessionID)
+    for k, v := range t.headers {
+        req.Header.Set(k, v)
+    }
+    resp, err := t.client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// sendMCPServerList 显示 MCP 服务器列表和本聊天的开关按钮；editMessageID 非零时原地更新消息
+func sendMCPServerList(bot *tgbotapi.BotAPI, chatID int64, threadID, editMessageID int) {
+    names := mcpServerNames()
+    if len(names) == 0 {
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "未配置任何 MCP 服务器"), threadID)
+        return
+    }
+
+    var sb strings.Builder
+    sb.WriteString("🧩 MCP 工具服务器\n")
+    var keyboard [][]tgbotapi.InlineKeyboardButton
+    for _, name := range names {
+        client := getMCPClient(name)
+        connected, tools, lastError := client.status()
+        enabled := client.enabledForChat(chatID)
+
+        status := "🟢 已连接"
+        if !connected {
+            status = "🔴 未连接"
+        }
+        sb.WriteString(fmt.Sprintf("\n%s  %s  工具: %d", name, status, tools))
+        if lastError != "" {
+            sb.WriteString(fmt.Sprintf("\n  错误: %s", lastError))
+        }
+
+        if !client.chatAllowed(chatID) {
+            sb.WriteString("\n  本聊天未获授权")
+            continue
+        }
+        label := "⬜ " + name
+        if enabled {
+            label = "✅ " + name
+        }
+        keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+            tgbotapi.NewInlineKeyboardButtonData(label, "mcp:"+name),
+        ))
+    }
+    sb.WriteString("\n\n点击按钮为本聊天启用或停用服务器")
+
+    if editMessageID != 0 {
+        edit := tgbotapi.NewEditMessageText(chatID, editMessageID, sb.String())
+        if len(keyboard) > 0 {
+            markup := tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+            edit.ReplyMarkup = &markup
+        }
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("EditMCPListError", err)
+        }
+        return
+    }
+
+    msg := tgbotapi.NewMessage(chatID, sb.String())
+    if len(keyboard) > 0 {
+        msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+    }
+    if _, err := sendThreaded(bot, msg, threadID); err != nil {
+        logEvent("SendMCPListError", err)
+    }
+}
+
+func handleMCPToggle(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    chatID := query.Message.Chat.ID
+    name := strings.TrimPrefix(query.Data, "mcp:")
+    client := getMCPClient(name)
+
+    var answer string
+    switch {
+    case !isAllowed(chatID, query.Message.Chat.UserName):
+        answer = "无权限"
+    case client == nil:
+        answer = "服务器不存在"
+    case !client.chatAllowed(chatID):
+        answer = "本聊天未获授权使用该服务器"
+    default:
+        enabled := !client.enabledForChat(chatID)
+        updateChatSettings(chatID, func(settings *ChatSettings) {
+            if settings.MCPServers == nil {
+                settings.MCPServers = map[string]bool{}
+            }
+            settings.MCPServers[name] = enabled
+        })
+        logEvent("MCPServerToggled", map[string]interface{}{
+            "chatID":  chatID,
+            "server":  name,
+            "enabled": enabled,
+        })
+        if enabled {
+            answer = "已启用 " + name
+        } else {
+            answer = "已停用 " + name
+        }
+        sendMCPServerList(bot, chatID, messageThreadID(query.Message), query.Message.MessageID)
+    }
+
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+    "fmt"
+    "hash/fnv"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type ModelPickerConfig struct {
+    PageSize int    `yaml:"page_size"`
+    GroupBy  string `yaml:"group_by"`
+}
+
+// Telegram 的 callback_data 最长 64 字节，模型 ID、分组名和搜索词都换成短令牌
+var (
+    tokenMu     sync.Mutex
+    tokenValues = map[string]string{}
+    tokenOrder  []string
+)
+
+// 最多记住的令牌数，超出后丢弃最早的记录，对应的旧按钮需要重新打开列表
+const maxTokenValues = 10000
+
+// hashToken 只计算短令牌，不记录原值；用于调用方自己按令牌保存状态的场景
+func hashToken(value string) string {
+    h := fnv.New64a()
+    h.Write([]byte(value))
+    return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// shortToken 计算短令牌并记录原值，之后可以用 tokenValue 还原
+func shortToken(value string) string {
+    token := hashToken(value)
+    tokenMu.Lock()
+    defer tokenMu.Unlock()
+    if _, ok := tokenValues[token]; !ok {
+        tokenOrder = append(tokenOrder, token)
+    }
+    tokenValues[token] = value
+    for len(tokenOrder) > maxTokenValues {
+        delete(tokenValues, tokenOrder[0])
+        tokenOrder = tokenOrder[1:]
+    }
+    return token
+}
+
+func tokenValue(token string) (string, bool) {
+    tokenMu.Lock()
+    defer tokenMu.Unlock()
+    value, ok := tokenValues[token]
+    return value, ok
+}
+
+// resolveModelToken 把回调中的令牌还原为模型 ID，兼容重启前发出的旧按钮中的完整 ID
+func resolveModelToken(token string) (string, bool) {
+    if model, ok := tokenValue(token); ok {
+        return model, true
+    }
+    for _, model := range pickerModels() {
+        if model.ID == token {
+            return model.ID, true
+        }
+    }
+    return "", false
+}
+
+func pickerPageSize() int {
+    if config.ModelPicker.PageSize > 0 {
+        return config.ModelPicker.PageSize
+    }
+    return 20
+}
+
+// pickerModels 返回列表中可选的模型，启用自动路由时 auto 排在最前
+func pickerModels() []OpenAIModel {
+    models := getOpenAIModels()
+    if config.AutoRoute.Enabled {
+        models = append([]OpenAIModel{{ID: autoModelID}}, models...)
+    }
+    return models
+}
+
+// modelGroup 按 owned_by 或模型名前缀分组
+func modelGroup(model OpenAIModel) string {
+    if model.ID == autoModelID {
+        return autoModelID
+    }
+    if config.ModelPicker.GroupBy == "owned_by" && model.OwnedBy != "" {
+        return model.OwnedBy
+    }
+    id := model.ID
+    if i := strings.Index(id, "/"); i > 0 {
+        return id[:i]
+    }
+    if i := strings.IndexAny(id, "-_:."); i > 0 {
+        return strings.ToLower(id[:i])
+    }
+    return strings.ToLower(id)
+}
+
+// modelView 描述当前显示的页面：root 为首页，group 为某个分组，search 为搜索结果，cap 为按能力筛选
+type modelView struct {
+    Kind  string
+    Value string
+    Page  int
+}
+
+func (v modelView) callback(page int) string {
+    switch v.Kind {
+    case "group":
+        return fmt.Sprintf("mdl:g:%s:%d", shortToken(v.Value), page)
+    case "search":
+        return fmt.Sprintf("mdl:s:%s:%d", shortToken(v.Value), page)
+    case "cap":
+        return fmt.Sprintf("mdl:c:%s:%d", v.Value, page)
+    }
+    return fmt.Sprintf("mdl:r:%d", page)
+}
+
+func chatFavoriteModels(chatID int64) []string {
+    var favorites []string
+    readChatSettings(chatID, func(settings *ChatSettings) {
+        favorites = append(favorites, settings.FavoriteModels...)
+    })
+    return favorites
+}
+
+func modelButton(model, current string, favorite bool) tgbotapi.InlineKeyboardButton {
+    label := model
+    if model == autoModelID {
+        label = "🧭 auto（自动路由）"
+    }
+    if favorite {
+        label = "⭐ " + label
+    }
+    if model == current {
+        label = "✔️ " + label
+    }
+    return tgbotapi.NewInlineKeyboardButtonData(label, "model:"+shortToken(model))
+}
+
+// sendModelPicker 显示角色可以使用的模型列表；editMessageID 非零时原地更新
+func sendModelPicker(bot *tgbotapi.BotAPI, chatID int64, key string, role *RoleConfig, editMessageID int, view modelView) {
+    models := role.filterModels(pickerModels())
+    current := sessionModel(key)
+    var favorites []string
+    for _, f := range chatFavoriteModels(chatID) {
+        if role.allowsModel(f) {
+            favorites = append(favorites, f)
+        }
+    }
+    isFavorite := map[string]bool{}
+    for _, f := range favorites {
+        isFavorite[f] = true
+    }
+
+    var title string
+    var list []OpenAIModel
+    var keyboard [][]tgbotapi.InlineKeyboardButton
+    grouped := config.ModelPicker.GroupBy != "none" && len(models) > pickerPageSize()
+
+    switch view.Kind {
+    case "search":
+        query := strings.ToLower(view.Value)
+        for _, model := range models {
+            if strings.Contains(strings.ToLower(model.ID), query) {
+                list = append(list, model)
+            }
+        }
+        title = fmt.Sprintf("🔍 搜索「%s」：%d 个模型", view.Value, len(list))
+    case "group":
+        for _, model := range models {
+            if modelGroup(model) == view.Value {
+                list = append(list, model)
+            }
+        }
+        title = fmt.Sprintf("📁 %s：%d 个模型", view.Value, len(list))
+    case "cap":
+        label := view.Value
+        for _, f := range capabilityFilters {
+            if f.Key == view.Value {
+                label = f.Label
+            }
+        }
+        for _, model := range models {
+            if modelSupports(model.ID, view.Value, true) {
+                list = append(list, model)
+            }
+        }
+        title = fmt.Sprintf("筛选 %s：%d 个模型", label, len(list))
+    default:
+        // 配置了能力表时提供按能力筛选
+        if len(config.Models.Capabilities) > 0 {
+            var filters []tgbotapi.InlineKeyboardButton
+            for _, f := range capabilityFilters {
+                filters = append(filters, tgbotapi.NewInlineKeyboardButtonData(f.Label, modelView{Kind: "cap", Value: f.Key}.callback(0)))
+            }
+            keyboard = append(keyboard, filters)
+        }
+        // 收藏固定在首页顶部
+        var row []tgbotapi.InlineKeyboardButton
+        for _, favorite := range favorites {
+            row = append(row, modelButton(favorite, current, true))
+            if len(row) == 2 {
+                keyboard = append(keyboard, row)
+                row = nil
+            }
+        }
+        if len(row) > 0 {
+            keyboard = append(keyboard, row)
+        }
+        if grouped {
+            counts := map[string]int{}
+            var groups []string
+            for _, model := range models {
+                group := modelGroup(model)
+                if counts[group] == 0 {
+                    groups = append(groups, group)
+                }
+                counts[group]++
+            }
+            sort.Strings(groups)
+            row = nil
+            for _, group := range groups {
+                row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📁 %s (%d)", group, counts[group]), modelView{Kind: "group", Value: group}.callback(0)))
+                if len(row) == 2 {
+                    keyboard = append(keyboard, row)
+                    row = nil
+                }
+            }
+            if len(row) > 0 {
+                keyboard = append(keyboard, row)
+            }
+            title = fmt.Sprintf("请选择一个模型（共 %d 个，按分组浏览，或发送 /models <关键词> 搜索）:", len(models))
+        } else {
+            for _, model := range models {
+                if !isFavorite[model.ID] {
+                    list = append(list, model)
+                }
+            }
+            title = "请选择一个模型:"
+        }
+    }
+
+    pages := (len(list) + pickerPageSize() - 1) / pickerPageSize()
+    page := view.Page
+    if page >= pages {
+        page = pages - 1
+    }
+    if page < 0 {
+        page = 0
+    }
+    start := page * pickerPageSize()
+    end := start + pickerPageSize()
+    if end > len(list) {
+        end = len(list)
+    }
+    if len(list) > 0 {
+        keyboard = append(keyboard, modelKeyboard(list[start:end], func(i int, model OpenAIModel) tgbotapi.InlineKeyboardButton {
+            return modelButton(model.ID, current, isFavorite[model.ID])
+        })...)
+    }
+
+    var nav []tgbotapi.InlineKeyboardButton
+    if page > 0 {
+        nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀️ 上一页", view.callback(page-1)))
+    }
+    if pages > 1 {
+        nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", page+1, pages), "mdl:n"))
+    }
+    if page < pages-1 {
+        nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("下一页 ▶️", view.callback(page+1)))
+    }
+    if len(nav) > 0 {
+        keyboard = append(keyboard, nav)
+    }
+    if view.Kind != "root" {
+        keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+            tgbotapi.NewInlineKeyboardButtonData("⬅️ 返回", "mdl:r:0"),
+        ))
+    }
+    if len(keyboard) == 0 {
+        title += "\n\n没有可选的模型"
+    }
+
+    markup := tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+    if editMessageID != 0 {
+        edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, editMessageID, title, markup)
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("EditModelListError", err)
+        }
+        return
+    }
+    msg := tgbotapi.NewMessage(chatID, title)
+    if len(keyboard) > 0 {
+        msg.ReplyMarkup = markup
+    }
+    sentMsg, err := sendThreaded(bot, msg, keyThreadID(key))
+    if err != nil {
+        logEvent("SendModelListError", err)
+    } else {
+        logEvent("ModelListSent", map[string]interface{}{
+            "message": sentMsg,
+        })
+    }
+}
+
+// handleModelPickerCallback 处理列表翻页和导航：mdl:r:<页>、mdl:g:<分组>:<页>、mdl:s:<关键词>:<页>、mdl:c:<能力>:<页>
+func handleModelPickerCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    parts := strings.Split(strings.TrimPrefix(query.Data, "mdl:"), ":")
+    answer := ""
+    view := modelView{Kind: "root"}
+    switch parts[0] {
+    case "r":
+        if len(parts) > 1 {
+            view.Page, _ = strconv.Atoi(parts[1])
+        }
+    case "g", "s":
+        if len(parts) < 3 {
+            break
+        }
+        value, ok := tokenValue(parts[1])
+        if !ok {
+            answer = "列表已过期，请重新发送 /models"
+            break
+        }
+        view.Kind = map[string]string{"g": "group", "s": "search"}[parts[0]]
+        view.Value = value
+        view.Page, _ = strconv.Atoi(parts[2])
+    case "c":
+        if len(parts) < 3 {
+            break
+        }
+        view.Kind = "cap"
+        view.Value = parts[1]
+        view.Page, _ = strconv.Atoi(parts[2])
+    case "n":
+        bot.Request(tgbotapi.NewCallback(query.ID, ""))
+        return
+    }
+    if answer == "" {
+        sendModelPicker(bot, query.Message.Chat.ID, callbackSessionKey(query), userRole(query.From.ID), query.Message.MessageID, view)
+    }
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
+
+// favoriteButton 返回模型切换确认消息中的收藏按钮
+func favoriteButton(chatID int64, model string) tgbotapi.InlineKeyboardMarkup {
+    label := "⭐ 收藏此模型"
+    for _, favorite := range chatFavoriteModels(chatID) {
+        if favorite == model {
+            label = "取消收藏"
+        }
+    }
+    return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+        tgbotapi.NewInlineKeyboardButtonData(label, "mfav:"+shortToken(model)),
+    ))
+}
+
+// handleFavoriteCallback 切换模型的收藏状态，收藏的模型固定显示在列表顶部
+func handleFavoriteCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    chatID := query.Message.Chat.ID
+    model, ok := resolveModelToken(strings.TrimPrefix(query.Data, "mfav:"))
+    if !ok {
+        bot.Request(tgbotapi.NewCallback(query.ID, "按钮已过期，请重新发送 /models"))
+        return
+    }
+
+    added := true
+    updateChatSettings(chatID, func(settings *ChatSettings) {
+        for i, favorite := range settings.FavoriteModels {
+            if favorite == model {
+                settings.FavoriteModels = append(settings.FavoriteModels[:i], settings.FavoriteModels[i+1:]...)
+                added = false
+                return
+            }
+        }
+        settings.FavoriteModels = append(settings.FavoriteModels, model)
+    })
+    logEvent("FavoriteModelToggled", map[string]interface{}{
+        "chatID": chatID,
+        "model":  model,
+        "added":  added,
+    })
+
+    edit := tgbotapi.NewEditMessageReplyMarkup(chatID, query.Message.MessageID, favoriteButton(chatID, model))
+    if _, err := bot.Request(edit); err != nil {
+        logEvent("EditFavoriteButtonError", err)
+    }
+    answer := "已取消收藏 " + model
+    if added {
+        answer = "已收藏 " + model
+    }
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
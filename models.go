@@ -0,0 +1,276 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "path"
+    "strings"
+    "sync"
+    "time"
+)
+
+type ModelsConfig struct {
+    CacheTTLMinutes int               `yaml:"cache_ttl_minutes"`
+    TimeoutSeconds  int               `yaml:"timeout_seconds"`
+    Capabilities    []ModelCapability `yaml:"capabilities"`
+}
+
+// ModelCapability 描述匹配模型的能力和价格，未填写的能力视为未知
+type ModelCapability struct {
+    Match         string  `yaml:"match"`
+    Vision        *bool   `yaml:"vision"`
+    Tools         *bool   `yaml:"tools"`
+    Reasoning     *bool   `yaml:"reasoning"`
+    ContextWindow int     `yaml:"context_window"`
+    InputPrice    float64 `yaml:"input_price"`
+    OutputPrice   float64 `yaml:"output_price"`
+}
+
+// capabilityFilters 是模型列表中可用的能力筛选
+var capabilityFilters = []struct {
+    Key   string
+    Label string
+}{
+    {"vision", "👁 视觉"},
+    {"tools", "🛠 工具"},
+    {"reasoning", "💭 推理"},
+}
+
+var (
+    modelsMu         sync.Mutex
+    cachedModels     []OpenAIModel
+    modelsFetchedAt  time.Time
+    modelsRefreshing bool
+    modelsFetching   chan struct{} // 正在请求时非空，请求结束后关闭，并发的调用等待同一次请求
+    modelsFailedAt   time.Time
+)
+
+// 获取失败后，在这段时间内没有缓存的调用直接返回空列表，不再同步请求
+const modelsRetryBackoff = 30 * time.Second
+
+func modelsCacheTTL() time.Duration {
+    if config.Models.CacheTTLMinutes > 0 {
+        return time.Duration(config.Models.CacheTTLMinutes) * time.Minute
+    }
+    return 10 * time.Minute
+}
+
+func modelsTimeout() time.Duration {
+    if config.Models.TimeoutSeconds > 0 {
+        return time.Duration(config.Models.TimeoutSeconds) * time.Second
+    }
+    return 15 * time.Second
+}
+
+// getOpenAIModels 返回缓存的模型列表；从未成功获取过时同步请求一次，缓存过期时在后台刷新
+func getOpenAIModels() []OpenAIModel {
+    modelsMu.Lock()
+    models := cachedModels
+    stale := time.Since(modelsFetchedAt) > modelsCacheTTL()
+    refreshing := modelsRefreshing
+    if stale && len(models) > 0 && !refreshing {
+        modelsRefreshing = true
+    }
+    modelsMu.Unlock()
+
+    if len(models) == 0 {
+        modelsMu.Lock()
+        backoff := time.Since(modelsFailedAt) < modelsRetryBackoff
+        modelsMu.Unlock()
+        if backoff {
+            return nil
+        }
+        return refreshModels()
+    }
+    if stale && !refreshing {
+        go func() {
+            refreshModels()
+        }()
+    }
+    return models
+}
+
+// refreshModels 请求上游 /models 并更新缓存，失败时保留旧缓存；已有请求在进行时等待它的结果
+func refreshModels() []OpenAIModel {
+    modelsMu.Lock()
+    if wait := modelsFetching; wait != nil {
+        modelsMu.Unlock()
+        <-wait
+        modelsMu.Lock()
+        defer modelsMu.Unlock()
+        return cachedModels
+    }
+    done := make(chan struct{})
+    modelsFetching = done
+    modelsMu.Unlock()
+
+    models, err := fetchModels()
+    modelsMu.Lock()
+    defer modelsMu.Unlock()
+    modelsFetching = nil
+    close(done)
+    modelsRefreshing = false
+    if err != nil {
+        modelsFailedAt = time.Now()
+        logEvent("RefreshModelsError", err.Error())
+        return cachedModels
+    }
+    cachedModels = models
+    modelsFetchedAt = time.Now()
+    logEvent("ModelsRefreshed", map[string]interface{}{
+        "count": len(models),
+    })
+    return models
+}
+
+// startModelRefresher 按缓存有效期定时在后台刷新模型列表
+func startModelRefresher() {
+    go func() {
+        ticker := time.NewTicker(modelsCacheTTL())
+        defer ticker.Stop()
+        for range ticker.C {
+            refreshModels()
+        }
+    }()
+}
+
+func fetchModels() ([]OpenAIModel, error) {
+    client := &http.Client{Timeout: modelsTimeout()}
+    req, err := http.NewRequest("GET", config.OpenAIConfig.APIURL+"/models", nil)
+    if err != nil {
+        logEvent("GetModelsRequestError", err)
+        return nil, err
+    }
+    req.Header.Add("Authorization", "Bearer "+config.OpenAIConfig.APIKey)
+
+    // Allow 在半开状态下会占用探测名额，之后的每条返回路径都需要 Record 释放
+    cb := getBreaker("/models")
+    if err := cb.Allow(); err != nil {
+        logEvent("GetModelsCircuitOpen", err.Error())
+        return nil, err
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        logEvent("GetModelsResponseError", err)
+        cb.Record(&upstreamError{msg: err.Error()})
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        logEvent("ReadModelsBodyError", err)
+        cb.Record(&upstreamError{msg: err.Error()})
+        return nil, err
+    }
+    if resp.StatusCode >= 500 {
+        logEvent("GetModelsServerError", map[string]interface{}{
+            "status": resp.StatusCode,
+        })
+        err := &upstreamError{msg: fmt.Sprintf("HTTP %d", resp.StatusCode)}
+        cb.Record(err)
+        return nil, err
+    }
+    cb.Record(nil)
+
+    var modelResp OpenAIModelResponse
+    if err := json.Unmarshal(body, &modelResp); err != nil {
+        logEvent("UnmarshalModelsError", err)
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+    }
+    return modelResp.Data, nil
+}
+
+// modelKnown 判断模型是否在已获取的列表中，列表为空时无法判断，视为存在
+func modelKnown(model string) bool {
+    models := getOpenAIModels()
+    if len(models) == 0 || model == autoModelID {
+        return true
+    }
+    for _, m := range models {
+        if m.ID == model {
+            return true
+        }
+    }
+    return false
+}
+
+// validateDefaultModel 启动时用已获取的模型列表检查默认模型是否存在，不存在时改用列表中的第一个模型
+func validateDefaultModel(models []OpenAIModel) {
+    if len(models) == 0 || currentModel == autoModelID {
+        return
+    }
+    if currentModel == "" {
+        currentModel = models[0].ID
+        return
+    }
+    for _, m := range models {
+        if m.ID == currentModel {
+            return
+        }
+    }
+    logEvent("DefaultModelNotFound", map[string]interface{}{
+        "model":    currentModel,
+        "fallback": models[0].ID,
+    })
+    currentModel = models[0].ID
+}
+
+// modelCapability 返回第一条匹配的能力配置
+func modelCapability(model string) (ModelCapability, bool) {
+    lower := strings.ToLower(model)
+    for _, c := range config.Models.Capabilities {
+        if ok, _ := path.Match(strings.ToLower(c.Match), lower); ok {
+            return c, true
+        }
+    }
+    return ModelCapability{}, false
+}
+
+// modelSupports 判断模型是否具备某项能力；strict 为 false 时未配置的能力视为支持
+func modelSupports(model, key string, strict bool) bool {
+    c, ok := modelCapability(model)
+    if !ok {
+        return !strict
+    }
+    var value *bool
+    switch key {
+    case "vision":
+        value = c.Vision
+    case "tools":
+        value = c.Tools
+    case "reasoning":
+        value = c.Reasoning
+    }
+    if value == nil {
+        return !strict
+    }
+    return *value
+}
+
+// capabilitySummary 返回模型能力的简短描述，没有配置时为空
+func capabilitySummary(model string) string {
+    c, ok := modelCapability(model)
+    if !ok {
+        return ""
+    }
+    var parts []string
+    for _, f := range capabilityFilters {
+        if modelSupports(model, f.Key, true) {
+            parts = append(parts, f.Label)
+        }
+    }
+    if c.ContextWindow > 0 {
+        parts = append(parts, fmt.Sprintf("上下文 %dK", c.ContextWindow/1000))
+    }
+    if c.InputPrice > 0 || c.OutputPrice > 0 {
+        parts = append(parts, fmt.Sprintf("价格 $%g/$%g 每百万 token", c.InputPrice, c.OutputPrice))
+    }
+    return strings.Join(parts, " · ")
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+    "fmt"
+    "path"
+    "strconv"
+    "strings"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// GenerationParams 是可选的生成参数，为空的字段不会发送给模型
+type GenerationParams struct {
+    Temperature      *float64 `yaml:"temperature" json:"temperature,omitempty"`
+    TopP             *float64 `yaml:"top_p" json:"top_p,omitempty"`
+    MaxTokens        *int     `yaml:"max_tokens" json:"max_tokens,omitempty"`
+    PresencePenalty  *float64 `yaml:"presence_penalty" json:"presence_penalty,omitempty"`
+    FrequencyPenalty *float64 `yaml:"frequency_penalty" json:"frequency_penalty,omitempty"`
+    Seed             *int     `yaml:"seed" json:"seed,omitempty"`
+    Stop             []string `yaml:"stop" json:"stop,omitempty"`
+    ReasoningEffort  string   `yaml:"reasoning_effort" json:"reasoning_effort,omitempty"`
+}
+
+// ModelParamsRule 按模型名通配符声明不支持的生成参数
+type ModelParamsRule struct {
+    Models      []string `yaml:"models"`
+    Unsupported []string `yaml:"unsupported"`
+}
+
+// 未配置 model_params 时使用的规则：o 系列推理模型不接受采样类参数
+var defaultModelParamsRules = []ModelParamsRule{
+    {
+        Models:      []string{"o1*", "o3*", "o4*"},
+        Unsupported: []string{"temperature", "top_p", "presence_penalty", "frequency_penalty"},
+    },
+}
+
+type paramSpec struct {
+    Key     string
+    Label   string
+    Hint    string
+    Choices []string
+}
+
+// paramSpecs 决定 /params 菜单中参数的顺序和快捷选项
+var paramSpecs = []paramSpec{
+    {Key: "temperature", Label: "温度", Hint: "0 ~ 2", Choices: []string{"0", "0.3", "0.7", "1", "1.5"}},
+    {Key: "top_p", Label: "Top P", Hint: "0 ~ 1", Choices: []string{"0.1", "0.5", "0.9", "1"}},
+    {Key: "max_tokens", Label: "最大输出", Hint: "正整数", Choices: []string{"512", "1024", "2048", "4096", "8192"}},
+    {Key: "presence_penalty", Label: "存在惩罚", Hint: "-2 ~ 2", Choices: []string{"-1", "0", "0.5", "1"}},
+    {Key: "frequency_penalty", Label: "频率惩罚", Hint: "-2 ~ 2", Choices: []string{"-1", "0", "0.5", "1"}},
+    {Key: "seed", Label: "随机种子", Hint: "整数", Choices: []string{"0", "42"}},
+    {Key: "stop", Label: "停止序列", Hint: "多个用 | 分隔"},
+    {Key: "reasoning_effort", Label: "推理强度", Hint: "minimal/low/medium/high", Choices: []string{"minimal", "low", "medium", "high"}},
+}
+
+func findParamSpec(key string) (paramSpec, bool) {
+    for _, spec := range paramSpecs {
+        if spec.Key == key {
+            return spec, true
+        }
+    }
+    return paramSpec{}, false
+}
+
+func modelParamsRules() []ModelParamsRule {
+    if len(config.ModelParams) > 0 {
+        return config.ModelParams
+    }
+    return defaultModelParamsRules
+}
+
+// paramSupported 判断模型是否支持某个参数
+func paramSupported(model, key string) bool {
+    model = strings.ToLower(model)
+    for _, rule := range modelParamsRules() {
+        matched := false
+        for _, pattern := range rule.Models {
+            if ok, _ := path.Match(strings.ToLower(pattern), model); ok {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            continue
+        }
+        for _, unsupported := range rule.Unsupported {
+            if unsupported == key {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// isSet 判断参数是否已设置
+func (p GenerationParams) isSet(key string) bool {
+    return p.value(key) != ""
+}
+
+// value 返回参数的显示值，未设置时为空
+func (p GenerationParams) value(key string) string {
+    formatFloat := func(f *float64) string {
+        if f == nil {
+            return ""
+        }
+        return strconv.FormatFloat(*f, 'f', -1, 64)
+    }
+    formatInt := func(i *int) string {
+        if i == nil {
+            return ""
+        }
+        return strconv.Itoa(*i)
+    }
+    switch key {
+    case "temperature":
+        return formatFloat(p.Temperature)
+    case "top_p":
+        return formatFloat(p.TopP)
+    case "max_tokens":
+        return formatInt(p.MaxTokens)
+    case "presence_penalty":
+        return formatFloat(p.PresencePenalty)
+    case "frequency_penalty":
+        return formatFloat(p.FrequencyPenalty)
+    case "seed":
+        return formatInt(p.Seed)
+    case "stop":
+        return strings.Join(p.Stop, " | ")
+    case "reasoning_effort":
+        return p.ReasoningEffort
+    }
+    return ""
+}
+
+// set 校验并设置参数，value 为空时清除该参数
+func (p *GenerationParams) set(key, value string) error {
+    value = strings.TrimSpace(value)
+    parseFloat := func(low, high float64) (*float64, error) {
+        f, err := strconv.ParseFloat(value, 64)
+        if err != nil || f < low || f > high {
+            return nil, fmt.Errorf("取值范围为 %g ~ %g", low, high)
+        }
+        return &f, nil
+    }
+    var err error
+    switch key {
+    case "temperature":
+        p.Temperature = nil
+        if value != "" {
+            p.Temperature, err = parseFloat(0, 2)
+        }
+    case "top_p":
+        p.TopP = nil
+        if value != "" {
+            p.TopP, err = parseFloat(0, 1)
+        }
+    case "presence_penalty":
+        p.PresencePenalty = nil
+        if value != "" {
+            p.PresencePenalty, err = parseFloat(-2, 2)
+        }
+    case "frequency_penalty":
+        p.FrequencyPenalty = nil
+        if value != "" {
+            p.FrequencyPenalty, err = parseFloat(-2, 2)
+        }
+    case "max_tokens":
+        p.MaxTokens = nil
+        if value != "" {
+            n, convErr := strconv.Atoi(value)
+            if convErr != nil || n <= 0 {
+                return fmt.Errorf("必须是正整数")
+            }
+            p.MaxTokens = &n
+        }
+    case "seed":
+        p.Seed = nil
+        if value != "" {
+            n, convErr := strconv.Atoi(value)
+            if convErr != nil {
+                return fmt.Errorf("必须是整数")
+            }
+            p.Seed = &n
+        }
+    case "stop":
+        p.Stop = nil
+        for _, s := range strings.Split(value, "|") {
+            if s = strings.TrimSpace(s); s != "" {
+                p.Stop = append(p.Stop, s)
+            }
+        }
+        if len(p.Stop) > 4 {
+            p.Stop = nil
+            return fmt.Errorf("最多 4 个停止序列")
+        }
+    case "reasoning_effort":
+        switch value {
+        case "", "minimal", "low", "medium", "high":
+            p.ReasoningEffort = value
+        default:
+            return fmt.Errorf("可选值为 minimal、low、medium、high")
+        }
+    default:
+        return fmt.Errorf("未知参数 %s", key)
+    }
+    return err
+}
+
+// forModel 去掉模型不支持的参数，切换模型后已保存的参数不会导致请求失败
+func (p GenerationParams) forModel(model string) GenerationParams {
+    var dropped []string
+    for _, spec := range paramSpecs {
+        if p.isSet(spec.Key) && !paramSupported(model, spec.Key) {
+            p.set(spec.Key, "")
+            dropped = append(dropped, spec.Key)
+        }
+    }
+    if len(dropped) > 0 {
+        logEvent("UnsupportedParamsDropped", map[string]interface{}{
+            "model":  model,
+            "params": dropped,
+        })
+    }
+    return p
+}
+
+// summary 返回已设置参数的简短描述
+func (p GenerationParams) summary() string {
+    var parts []string
+    for _, spec := range paramSpecs {
+        if v := p.value(spec.Key); v != "" {
+            parts = append(parts, fmt.Sprintf("%s=%s", spec.Key, v))
+        }
+    }
+    if len(parts) == 0 {
+        return "默认"
+    }
+    return strings.Join(parts, ", ")
+}
+
+// sessionParams 返回当前会话的模型和生成参数
+func sessionParams(key string) (string, GenerationParams) {
+    var model string
+    var params GenerationParams
+    withActiveSession(key, func(sess *Session) {
+        model, params = sess.Model, sess.Params
+    })
+    return model, params
+}
+
+// updateSessionParams 修改当前会话的参数，并作为该聊天新会话的默认参数
+func updateSessionParams(chatID int64, key string, fn func(params *GenerationParams, model string) error) (string, GenerationParams, error) {
+    var model string
+    var params GenerationParams
+    var err error
+    withActiveSession(key, func(sess *Session) {
+        next := sess.Params
+        next.Stop = append([]string(nil), sess.Params.Stop...)
+        if err = fn(&next, sess.Model); err != nil {
+            return
+        }
+        sess.Params = next
+        model, params = sess.Model, next
+    })
+    if err != nil {
+        return "", GenerationParams{}, err
+    }
+    updateChatSettings(chatID, func(settings *ChatSettings) {
+        settings.Params = &params
+    })
+    return model, params, nil
+}
+
+// handleParamsCommand 处理 /params：无参数时打开菜单，/params <参数> <值> 直接设置，/params reset 全部恢复默认
+func handleParamsCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    chatID := message.Chat.ID
+    key := sessionKeyFor(message)
+    args := strings.Fields(message.CommandArguments())
+
+    if len(args) == 0 {
+        sendParamsMenu(bot, chatID, key, 0)
+        return
+    }
+
+    var model string
+    var params GenerationParams
+    var err error
+    if args[0] == "reset" {
+        model, params, err = updateSessionParams(chatID, key, func(p *GenerationParams, model string) error {
+            *p = GenerationParams{}
+            return nil
+        })
+    } else {
+        name := args[0]
+        value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), name))
+        model, params, err = updateSessionParams(chatID, key, func(p *GenerationParams, model string) error {
+            return applyParam(p, model, name, value)
+        })
+    }
+    if err != nil {
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("设置失败：%v", err)), messageThreadID(message))
+        return
+    }
+    logEvent("GenerationParamsUpdated", map[string]interface{}{
+        "chatID": chatID,
+        "params": params.summary(),
+    })
+    sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 生成参数已更新\n⚙️ 模型: %s\n🎛 参数: %s", model, params.summary())), messageThreadID(message))
+}
+
+// applyParam 按模型校验后设置单个参数，value 为空或 default 时清除
+func applyParam(p *GenerationParams, model, name, value string) error {
+    if _, ok := findParamSpec(name); !ok {
+        return fmt.Errorf("未知参数 %s", name)
+    }
+    if value == "default" {
+        value = ""
+    }
+    if value != "" && !paramSupported(model, name) {
+        return fmt.Errorf("模型 %s 不支持 %s", model, name)
+    }
+    return p.set(name, value)
+}
+
+// sendParamsMenu 显示参数菜单；editMessageID 非零时原地更新
+func sendParamsMenu(bot *tgbotapi.BotAPI, chatID int64, key string, editMessageID int) {
+    model, params := sessionParams(key)
+
+    var sb strings.Builder
+    sb.WriteString(fmt.Sprintf("🎛 生成参数（模型: %s）\n", model))
+    var keyboard [][]tgbotapi.InlineKeyboardButton
+    var row []tgbotapi.InlineKeyboardButton
+    for _, spec := range paramSpecs {
+        value := params.value(spec.Key)
+        if value == "" {
+            value = "默认"
+        }
+        if !paramSupported(model, spec.Key) {
+            value += "（当前模型不支持）"
+        }
+        sb.WriteString(fmt.Sprintf("\n• %s %s: %s", spec.Label, spec.Key, value))
+
+        row = append(row, tgbotapi.NewInlineKeyboardButtonData(spec.Label, "params:edit:"+spec.Key))
+        if len(row) == 2 {
+            keyboard = append(keyboard, row)
+            row = nil
+        }
+    }
+    if len(row) > 0 {
+        keyboard = append(keyboard, row)
+    }
+    keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+        tgbotapi.NewInlineKeyboardButtonData("♻️ 全部恢复默认", "params:reset"),
+    ))
+    sb.WriteString("\n\n也可以发送 /params <参数> <值> 直接设置，如 /params temperature 0.7")
+
+    markup := tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+    if editMessageID != 0 {
+        edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, editMessageID, sb.String(), markup)
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("EditParamsMenuError", err)
+        }
+        return
+    }
+    msg := tgbotapi.NewMessage(chatID, sb.String())
+    msg.ReplyMarkup = markup
+    if _, err := sendThreaded(bot, msg, keyThreadID(key)); err != nil {
+        logEvent("SendParamsMenuError", err)
+    }
+}
+
+// sendParamChoices 显示单个参数的快捷选项
+func sendParamChoices(bot *tgbotapi.BotAPI, chatID int64, key string, editMessageID int, spec paramSpec) {
+    model, params := sessionParams(key)
+    value := params.value(spec.Key)
+    if value == "" {
+        value = "默认"
+    }
+    text := fmt.Sprintf("🎛 %s %s\n当前值: %s\n取值: %s\n\n也可以发送 /params %s <值> 设置自定义值", spec.Label, spec.Key, value, spec.Hint, spec.Key)
+    if !paramSupported(model, spec.Key) {
+        text += fmt.Sprintf("\n\n⚠️ 当前模型 %s 不支持该参数", model)
+    }
+
+    var keyboard [][]tgbotapi.InlineKeyboardButton
+    var row []tgbotapi.InlineKeyboardButton
+    for _, choice := range spec.Choices {
+        row = append(row, tgbotapi.NewInlineKeyboardButtonData(choice, "params:set:"+spec.Key+":"+choice))
+        if len(row) == 4 {
+            keyboard = append(keyboard, row)
+            row = nil
+        }
+    }
+    if len(row) > 0 {
+        keyboard = append(keyboard, row)
+    }
+    keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+        tgbotapi.NewInlineKeyboardButtonData("默认", "params:set:"+spec.Key+":"),
+        tgbotapi.NewInlineKeyboardButtonData("⬅️ 返回", "params:menu"),
+    ))
+
+    edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, editMessageID, text, tgbotapi.NewInlineKeyboardMarkup(keyboard...))
+    if _, err := bot.Send(edit); err != nil {
+        logEvent("EditParamsMenuError", err)
+    }
+}
+
+// handleParamsCallback 处理参数菜单的按钮：params:menu、params:edit:<参数>、params:set:<参数>:<值>、params:reset
+func handleParamsCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    chatID := query.Message.Chat.ID
+    key := callbackSessionKey(query)
+    parts := strings.SplitN(strings.TrimPrefix(query.Data, "params:"), ":", 3)
+    answer := ""
+
+    switch parts[0] {
+    case "menu":
+        sendParamsMenu(bot, chatID, key, query.Message.MessageID)
+    case "edit":
+        spec, ok := findParamSpec(parts[len(parts)-1])
+        if !ok {
+            answer = "未知参数"
+            break
+        }
+        sendParamChoices(bot, chatID, key, query.Message.MessageID, spec)
+    case "set":
+        if len(parts) < 3 {
+            answer = "无效操作"
+            break
+        }
+        _, params, err := updateSessionParams(chatID, key, func(p *GenerationParams, model string) error {
+            return applyParam(p, model, parts[1], parts[2])
+        })
+        if err != nil {
+            answer = err.Error()
+            break
+        }
+        logEvent("GenerationParamsUpdated", map[string]interface{}{
+            "chatID": chatID,
+            "params": params.summary(),
+        })
+        answer = "已更新 " + parts[1]
+        sendParamsMenu(bot, chatID, key, query.Message.MessageID)
+    case "reset":
+        updateSessionParams(chatID, key, func(p *GenerationParams, model string) error {
+            *p = GenerationParams{}
+            return nil
+        })
+        answer = "已恢复默认参数"
+        sendParamsMenu(bot, chatID, key, query.Message.MessageID)
+    }
+
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
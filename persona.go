@@ -0,0 +1,153 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// PresetConfig 是配置文件中的人设预设：系统提示词、默认模型和生成参数
+type PresetConfig struct {
+    Name        string           `yaml:"name"`
+    Description string           `yaml:"description"`
+    Prompt      string           `yaml:"prompt"`
+    Model       string           `yaml:"model"`
+    Params      GenerationParams `yaml:"params"`
+}
+
+// handleSystemCommand 处理 /system：无参数时查看，/system reset 重置，其余内容作为新的系统提示词
+func handleSystemCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    chatID := message.Chat.ID
+    key := sessionKeyFor(message)
+    args := strings.TrimSpace(message.CommandArguments())
+
+    switch {
+    case args == "":
+        var prompt, persona string
+        withActiveSession(key, func(sess *Session) {
+            prompt, persona = sess.SystemPrompt, sess.Persona
+        })
+        if prompt == "" {
+            prompt = "（未设置）"
+        }
+        text := "📝 当前会话的系统提示词：\n\n" + prompt
+        if persona != "" {
+            text += "\n\n🎭 人设: " + persona
+        }
+        text += "\n\n用法：/system <提示词> 设置，/system reset 恢复默认\n" +
+            "支持模板变量：{{.Date}} {{.Time}} {{.DateTime}} {{.Weekday}} {{.Timezone}} {{.UserName}} {{.ChatTitle}} {{.Model}} {{.Persona}} {{.Version}}"
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, text), keyThreadID(key))
+
+    case args == "reset":
+        if threadID := keyThreadID(key); threadID != 0 {
+            updateTopicSettings(chatID, threadID, func(topic *TopicSettings) {
+                topic.SystemPrompt = nil
+            })
+        } else {
+            updateChatSettings(chatID, func(settings *ChatSettings) {
+                settings.SystemPrompt = nil
+            })
+        }
+        withActiveSession(key, func(sess *Session) {
+            sess.SystemPrompt, _, _ = sessionDefaultsLocked(key)
+            sess.Persona = ""
+        })
+        logEvent("SystemPromptReset", map[string]interface{}{
+            "chatID": chatID,
+        })
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "✅ 系统提示词已恢复为默认配置"), keyThreadID(key))
+
+    default:
+        prompt := args
+        if err := validatePromptTemplate(prompt); err != nil {
+            sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("系统提示词模板有误：%v", err)), keyThreadID(key))
+            return
+        }
+        // 论坛话题中设置的提示词只作用于该话题
+        if threadID := keyThreadID(key); threadID != 0 {
+            updateTopicSettings(chatID, threadID, func(topic *TopicSettings) {
+                topic.SystemPrompt = &prompt
+            })
+        } else {
+            updateChatSettings(chatID, func(settings *ChatSettings) {
+                settings.SystemPrompt = &prompt
+            })
+        }
+        withActiveSession(key, func(sess *Session) {
+            sess.SystemPrompt = prompt
+            sess.Persona = ""
+        })
+        logEvent("SystemPromptSet", map[string]interface{}{
+            "chatID": chatID,
+            "prompt": prompt,
+        })
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "✅ 系统提示词已更新，对当前会话和之后的新会话生效"), keyThreadID(key))
+    }
+}
+
+// sendPersonaList 以内联键盘列出配置中的人设预设
+func sendPersonaList(bot *tgbotapi.BotAPI, chatID int64, threadID int) {
+    if len(config.Presets) == 0 {
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "未配置任何人设预设"), threadID)
+        return
+    }
+
+    var sb strings.Builder
+    sb.WriteString("🎭 请选择人设：\n")
+    var keyboard [][]tgbotapi.InlineKeyboardButton
+    for i, preset := range config.Presets {
+        line := fmt.Sprintf("\n• %s", preset.Name)
+        if preset.Description != "" {
+            line += "：" + preset.Description
+        }
+        if preset.Model != "" {
+            line += fmt.Sprintf("（%s）", preset.Model)
+        }
+        sb.WriteString(line)
+        keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+            tgbotapi.NewInlineKeyboardButtonData(preset.Name, "persona:"+strconv.Itoa(i)),
+        ))
+    }
+
+    msg := tgbotapi.NewMessage(chatID, sb.String())
+    msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+    if _, err := sendThreaded(bot, msg, threadID); err != nil {
+        logEvent("SendPersonaListError", err)
+    }
+}
+
+// handlePersonaSelect 把选中的人设应用到当前会话
+func handlePersonaSelect(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    chatID := query.Message.Chat.ID
+    index, err := strconv.Atoi(strings.TrimPrefix(query.Data, "persona:"))
+    if err != nil || index < 0 || index >= len(config.Presets) {
+        bot.Request(tgbotapi.NewCallback(query.ID, "人设不存在"))
+        return
+    }
+    preset := config.Presets[index]
+
+    var model string
+    withActiveSession(callbackSessionKey(query), func(sess *Session) {
+        sess.Persona = preset.Name
+        sess.SystemPrompt = preset.Prompt
+        if preset.Model != "" {
+            sess.Model = preset.Model
+        }
+        sess.Params = preset.Params
+        model = sess.Model
+    })
+    logEvent("PersonaSelected", map[string]interface{}{
+        "chatID":  chatID,
+        "persona": preset.Name,
+    })
+
+    edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, fmt.Sprintf("🎭 已切换人设：%s\n⚙️ 模型: %s", preset.Name, model))
+    if _, err := bot.Send(edit); err != nil {
+        logEvent("EditPersonaMessageError", err)
+    }
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, "已切换人设 "+preset.Name)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+    "text/template"
+    "text/template/parse"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// promptVars 是系统提示词模板可用的变量，每次请求时重新生成
+type promptVars struct {
+    Now       time.Time
+    Date      string
+    Time      string
+    DateTime  string
+    Weekday   string
+    Timezone  string
+    UserName  string
+    Username  string
+    ChatTitle string
+    Model     string
+    Persona   string
+    Version   string
+}
+
+var weekdayNames = []string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
+
+// chatLocation 返回聊天设置的时区，未设置时使用全局时区
+func chatLocation(chatID int64) *time.Location {
+    var name string
+    readChatSettings(chatID, func(settings *ChatSettings) {
+        name = settings.Timezone
+    })
+    if name != "" {
+        if loc, err := time.LoadLocation(name); err == nil {
+            return loc
+        }
+    }
+    return defaultLocation()
+}
+
+// newPromptVars 根据消息生成模板变量，Model 和 Persona 由调用方按会话填写
+func newPromptVars(message *tgbotapi.Message) promptVars {
+    vars := userPromptVars(chatLocation(message.Chat.ID), message.From)
+    if message.Chat.Title != "" {
+        vars.ChatTitle = message.Chat.Title
+    }
+    return vars
+}
+
+// userPromptVars 生成不依赖聊天的模板变量，聊天标题默认为用户名字
+func userPromptVars(loc *time.Location, user *tgbotapi.User) promptVars {
+    now := time.Now().In(loc)
+    vars := promptVars{
+        Now:      now,
+        Date:     now.Format("2006-01-02"),
+        Time:     now.Format("15:04"),
+        DateTime: now.Format("2006-01-02 15:04:05"),
+        Weekday:  weekdayNames[now.Weekday()],
+        Timezone: now.Location().String(),
+        Version:  version,
+    }
+    if user != nil {
+        vars.UserName = user.FirstName
+        vars.Username = user.UserName
+    }
+    vars.ChatTitle = vars.UserName
+    return vars
+}
+
+// parsePromptTemplate 解析系统提示词模板，缺失的变量渲染为空
+// 提示词由用户提交，只允许 {{.变量}} 形式的替换，range、with、template 和函数调用等语法一律拒绝
+func parsePromptTemplate(prompt string) (*template.Template, error) {
+    tmpl, err := template.New("prompt").Option("missingkey=zero").Parse(prompt)
+    if err != nil {
+        return nil, err
+    }
+    if len(tmpl.Templates()) > 1 {
+        return nil, fmt.Errorf("不支持 define 和 block")
+    }
+    if tmpl.Tree == nil {
+        return tmpl, nil
+    }
+    for _, node := range tmpl.Tree.Root.Nodes {
+        switch n := node.(type) {
+        case *parse.TextNode, *parse.CommentNode:
+            continue
+        case *parse.ActionNode:
+            if len(n.Pipe.Decl) == 0 && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+                if field, ok := n.Pipe.Cmds[0].Args[0].(*parse.FieldNode); ok && len(field.Ident) == 1 {
+                    continue
+                }
+            }
+        }
+        return nil, fmt.Errorf("只支持 {{.变量}} 形式的模板变量，不支持 %s", node)
+    }
+    return tmpl, nil
+}
+
+// renderSystemPrompt 渲染系统提示词，不含模板语法时原样返回，出错时记录日志并使用原文
+func renderSystemPrompt(prompt string, vars promptVars) string {
+    if !strings.Contains(prompt, "{{") {
+        return prompt
+    }
+    tmpl, err := parsePromptTemplate(prompt)
+    if err != nil {
+        logEvent("ParsePromptTemplateError", err.Error())
+        return prompt
+    }
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, vars); err != nil {
+        logEvent("RenderPromptTemplateError", err.Error())
+        return prompt
+    }
+    return buf.String()
+}
+
+// validatePromptTemplate 用示例变量试渲染一次，用于设置提示词时提前发现错误
+func validatePromptTemplate(prompt string) error {
+    tmpl, err := parsePromptTemplate(prompt)
+    if err != nil {
+        return err
+    }
+    return tmpl.Execute(&bytes.Buffer{}, promptVars{Now: time.Now()})
+}
+
+// handleTimezoneCommand 处理 /timezone：查看或设置当前聊天的时区
+func handleTimezoneCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    chatID := message.Chat.ID
+    args := strings.TrimSpace(message.CommandArguments())
+
+    switch args {
+    case "":
+        loc := chatLocation(chatID)
+        text := fmt.Sprintf("🕒 当前时区：%s（%s）\n\n用法：/timezone <时区> 设置，如 Asia/Shanghai；/timezone reset 恢复默认",
+            loc.String(), time.Now().In(loc).Format("2006-01-02 15:04"))
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, text), messageThreadID(message))
+    case "reset":
+        updateChatSettings(chatID, func(settings *ChatSettings) {
+            settings.Timezone = ""
+        })
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "✅ 时区已恢复为默认："+defaultLocation().String()), messageThreadID(message))
+    default:
+        loc, err := time.LoadLocation(args)
+        if err != nil {
+            sendThreaded(bot, tgbotapi.NewMessage(chatID, "无效的时区："+args), messageThreadID(message))
+            return
+        }
+        updateChatSettings(chatID, func(settings *ChatSettings) {
+            settings.Timezone = loc.String()
+        })
+        logEvent("ChatTimezoneSet", map[string]interface{}{
+            "chatID":   chatID,
+            "timezone": loc.String(),
+        })
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 时区已设置为 %s，当前时间 %s", loc.String(), time.Now().In(loc).Format("2006-01-02 15:04"))), messageThreadID(message))
+    }
+}
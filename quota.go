@@ -0,0 +1,336 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// QuotasConfig 是默认的使用额度，可在访问列表中为单个用户或群组单独设置
+type QuotasConfig struct {
+    Users       QuotaLimits `yaml:"users"`
+    Groups      QuotaLimits `yaml:"groups"`
+    WarnPercent int         `yaml:"warn_percent"`
+}
+
+// QuotaLimits 是使用额度，0 表示不限制
+type QuotaLimits struct {
+    DailyRequests   int `yaml:"daily_requests" json:"daily_requests,omitempty"`
+    WeeklyRequests  int `yaml:"weekly_requests" json:"weekly_requests,omitempty"`
+    MonthlyRequests int `yaml:"monthly_requests" json:"monthly_requests,omitempty"`
+    DailyTokens     int `yaml:"daily_tokens" json:"daily_tokens,omitempty"`
+    WeeklyTokens    int `yaml:"weekly_tokens" json:"weekly_tokens,omitempty"`
+    MonthlyTokens   int `yaml:"monthly_tokens" json:"monthly_tokens,omitempty"`
+}
+
+// usagePeriod 记录一个周期内的用量，周期变化时清零
+type usagePeriod struct {
+    Period         string `json:"period"`
+    Requests       int    `json:"requests"`
+    Tokens         int    `json:"tokens"`
+    WarnedRequests bool   `json:"warned_requests,omitempty"`
+    WarnedTokens   bool   `json:"warned_tokens,omitempty"`
+}
+
+// usageCounter 记录用户或群组按天、周、月统计的用量
+type usageCounter struct {
+    Daily   usagePeriod `json:"daily"`
+    Weekly  usagePeriod `json:"weekly"`
+    Monthly usagePeriod `json:"monthly"`
+}
+
+// quotaWindow 把一个周期的用量和对应的额度放在一起
+type quotaWindow struct {
+    Label    string
+    Usage    *usagePeriod
+    Requests int
+    Tokens   int
+}
+
+// quotaScope 是一次请求需要计量的对象：发送者本人，群聊中还有所在的群
+type quotaScope struct {
+    Key    string
+    Name   string
+    Limits QuotaLimits
+}
+
+func quotaWarnPercent() int {
+    if config.Quotas.WarnPercent > 0 {
+        return config.Quotas.WarnPercent
+    }
+    return 80
+}
+
+func (p *usagePeriod) roll(period string) {
+    if p.Period != period {
+        *p = usagePeriod{Period: period}
+    }
+}
+
+// windows 按当前时间重置过期的周期，返回天、周、月三个周期；周从周一开始
+func (c *usageCounter) windows(now time.Time, q QuotaLimits) []quotaWindow {
+    t := now.In(defaultLocation())
+    year, week := t.ISOWeek()
+    c.Daily.roll(t.Format("2006-01-02"))
+    c.Weekly.roll(fmt.Sprintf("%d-W%02d", year, week))
+    c.Monthly.roll(t.Format("2006-01"))
+    return []quotaWindow{
+        {Label: "今日", Usage: &c.Daily, Requests: q.DailyRequests, Tokens: q.DailyTokens},
+        {Label: "本周", Usage: &c.Weekly, Requests: q.WeeklyRequests, Tokens: q.WeeklyTokens},
+        {Label: "本月", Usage: &c.Monthly, Requests: q.MonthlyRequests, Tokens: q.MonthlyTokens},
+    }
+}
+
+// merge 用 override 中非零的项覆盖默认额度
+func (q QuotaLimits) merge(override *QuotaLimits) QuotaLimits {
+    if override == nil {
+        return q
+    }
+    pick := func(base, o int) int {
+        if o > 0 {
+            return o
+        }
+        return base
+    }
+    return QuotaLimits{
+        DailyRequests:   pick(q.DailyRequests, override.DailyRequests),
+        WeeklyRequests:  pick(q.WeeklyRequests, override.WeeklyRequests),
+        MonthlyRequests: pick(q.MonthlyRequests, override.MonthlyRequests),
+        DailyTokens:     pick(q.DailyTokens, override.DailyTokens),
+        WeeklyTokens:    pick(q.WeeklyTokens, override.WeeklyTokens),
+        MonthlyTokens:   pick(q.MonthlyTokens, override.MonthlyTokens),
+    }
+}
+
+func (q *QuotaLimits) summary() string {
+    if q == nil {
+        return "不限"
+    }
+    var parts []string
+    add := func(label string, n int, unit string) {
+        if n > 0 {
+            parts = append(parts, fmt.Sprintf("%s %d %s", label, n, unit))
+        }
+    }
+    add("每天", q.DailyRequests, "次")
+    add("每周", q.WeeklyRequests, "次")
+    add("每月", q.MonthlyRequests, "次")
+    add("每天", q.DailyTokens, "Token")
+    add("每周", q.WeeklyTokens, "Token")
+    add("每月", q.MonthlyTokens, "Token")
+    if len(parts) == 0 {
+        return "不限"
+    }
+    return strings.Join(parts, "，")
+}
+
+// usageLocked 返回 ID 对应的用量记录，不存在时创建，调用方需持有 stateMu
+func usageLocked(key string) *usageCounter {
+    if state.Usage == nil {
+        state.Usage = map[string]*usageCounter{}
+    }
+    counter, ok := state.Usage[key]
+    if !ok {
+        counter = &usageCounter{}
+        state.Usage[key] = counter
+    }
+    return counter
+}
+
+// quotaLimitsLocked 返回用户或群组的额度：访问列表中单独设置的额度覆盖配置中的默认值，管理员不受限制，调用方需持有 stateMu
+func quotaLimitsLocked(id int64, group bool) QuotaLimits {
+    if !group && isAdmin(id) {
+        return QuotaLimits{}
+    }
+    defaults := config.Quotas.Users
+    if group {
+        defaults = config.Quotas.Groups
+    }
+    var override *QuotaLimits
+    if entry := accessLocked().Users[chatKey(id)]; entry != nil {
+        override = entry.Quota
+    }
+    return defaults.merge(override)
+}
+
+// quotaScopesLocked 返回一次请求需要计量的对象，chat 为 nil 时（如内联查询）只计量用户，调用方需持有 stateMu
+func quotaScopesLocked(user *tgbotapi.User, chat *tgbotapi.Chat) []quotaScope {
+    var scopes []quotaScope
+    if user != nil {
+        scopes = append(scopes, quotaScope{Key: chatKey(user.ID), Name: "你", Limits: quotaLimitsLocked(user.ID, false)})
+    }
+    if chat != nil && isGroupChat(chat) {
+        scopes = append(scopes, quotaScope{Key: chatKey(chat.ID), Name: "本群", Limits: quotaLimitsLocked(chat.ID, true)})
+    }
+    return scopes
+}
+
+// takeQuota 检查用户和所在群组的额度并计入一次请求，额度用完时返回提示且不计入
+func takeQuota(user *tgbotapi.User, chat *tgbotapi.Chat) string {
+    now := time.Now()
+    var exceeded string
+
+    // 管理员只计量不限制
+    exempt := user != nil && isAdmin(user.ID)
+
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    scopes := quotaScopesLocked(user, chat)
+    for _, scope := range scopes {
+        if exempt || exceeded != "" {
+            break
+        }
+        for _, w := range usageLocked(scope.Key).windows(now, scope.Limits) {
+            if w.Requests > 0 && w.Usage.Requests >= w.Requests {
+                exceeded = fmt.Sprintf("%s%s的请求额度（%d 次）已用完", scope.Name, w.Label, w.Requests)
+            } else if w.Tokens > 0 && w.Usage.Tokens >= w.Tokens {
+                exceeded = fmt.Sprintf("%s%s的 Token 额度（%d）已用完", scope.Name, w.Label, w.Tokens)
+            }
+            if exceeded != "" {
+                break
+            }
+        }
+    }
+    if exceeded != "" {
+        logEvent("QuotaExceeded", map[string]interface{}{
+            "scopes": len(scopes),
+            "reason": exceeded,
+        })
+        return exceeded
+    }
+    for _, scope := range scopes {
+        for _, w := range usageLocked(scope.Key).windows(now, scope.Limits) {
+            w.Usage.Requests++
+        }
+    }
+    saveStateLocked()
+    return ""
+}
+
+// consumeQuota 在请求模型前检查发送者和所在群组的额度并计入一次请求，额度用完时告知用户并返回 false
+func consumeQuota(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+    if exceeded := takeQuota(message.From, message.Chat); exceeded != "" {
+        text := exceeded + "，额度会在下个周期自动恢复，可用 /usage 查看用量"
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, text), messageThreadID(message))
+        return false
+    }
+    warnQuota(bot, message)
+    return true
+}
+
+// addUsage 把一次请求消耗的 Token 计入全局统计，以及用户和所在群组的用量；user 和 chat 都为 nil 时只计入全局统计
+func addUsage(user *tgbotapi.User, chat *tgbotapi.Chat, result CompletionResult) {
+    now := time.Now()
+    tokens := result.InputTokens + result.OutputTokens
+
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    totalInputTokens += result.InputTokens
+    totalOutputTokens += result.OutputTokens
+    scopes := quotaScopesLocked(user, chat)
+    for _, scope := range scopes {
+        for _, w := range usageLocked(scope.Key).windows(now, scope.Limits) {
+            w.Usage.Tokens += tokens
+        }
+    }
+    if len(scopes) > 0 {
+        saveStateLocked()
+    }
+}
+
+// tokenTotals 返回启动以来的输入和输出 Token 总数
+func tokenTotals() (int, int) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    return totalInputTokens, totalOutputTokens
+}
+
+// recordUsage 计入消息对应请求的 Token 用量
+func recordUsage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, result CompletionResult) {
+    addUsage(message.From, message.Chat, result)
+    warnQuota(bot, message)
+}
+
+// quotaWarnings 返回首次超过额度 warn_percent 的提醒，每个周期只提醒一次
+func quotaWarnings(user *tgbotapi.User, chat *tgbotapi.Chat) []string {
+    now := time.Now()
+    percent := quotaWarnPercent()
+    var warnings []string
+
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    for _, scope := range quotaScopesLocked(user, chat) {
+        for _, w := range usageLocked(scope.Key).windows(now, scope.Limits) {
+            if !w.Usage.WarnedRequests && w.Requests > 0 && w.Usage.Requests*100 >= w.Requests*percent {
+                warnings = append(warnings, fmt.Sprintf("%s%s的请求额度已使用 %d/%d 次", scope.Name, w.Label, w.Usage.Requests, w.Requests))
+                w.Usage.WarnedRequests = true
+            }
+            if !w.Usage.WarnedTokens && w.Tokens > 0 && w.Usage.Tokens*100 >= w.Tokens*percent {
+                warnings = append(warnings, fmt.Sprintf("%s%s的 Token 额度已使用 %d/%d", scope.Name, w.Label, w.Usage.Tokens, w.Tokens))
+                w.Usage.WarnedTokens = true
+            }
+        }
+    }
+    if len(warnings) > 0 {
+        saveStateLocked()
+        logEvent("QuotaWarning", warnings)
+    }
+    return warnings
+}
+
+func formatQuotaWarnings(warnings []string) string {
+    return "⚠️ " + strings.Join(warnings, "\n⚠️ ")
+}
+
+// warnQuota 在消息所在的聊天中发送额度提醒
+func warnQuota(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    if warnings := quotaWarnings(message.From, message.Chat); len(warnings) > 0 {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, formatQuotaWarnings(warnings)), messageThreadID(message))
+    }
+}
+
+// handleUsageCommand 处理 /usage：显示发送者和所在群组的用量，管理员可以查看指定 ID 的用量
+func handleUsageCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    now := time.Now()
+    var sb strings.Builder
+    sb.WriteString("📊 使用额度\n")
+    sb.WriteString("──────────────\n")
+
+    stateMu.Lock()
+    scopes := quotaScopesLocked(message.From, message.Chat)
+    if arg := strings.TrimSpace(message.CommandArguments()); arg != "" && message.From != nil && isAdmin(message.From.ID) {
+        id, err := strconv.ParseInt(arg, 10, 64)
+        if err != nil {
+            stateMu.Unlock()
+            sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, "用法：/usage [用户或群组ID]"), messageThreadID(message))
+            return
+        }
+        scopes = []quotaScope{{Key: chatKey(id), Name: chatKey(id), Limits: quotaLimitsLocked(id, id < 0)}}
+    }
+    for _, scope := range scopes {
+        sb.WriteString(fmt.Sprintf("👤 %s\n", scope.Name))
+        // 只读取用量，在副本上按周期清零，不为查询的 ID 创建记录
+        var counter usageCounter
+        if c := state.Usage[scope.Key]; c != nil {
+            counter = *c
+        }
+        for _, w := range counter.windows(now, scope.Limits) {
+            sb.WriteString(fmt.Sprintf("    %s  请求 %s  Token %s\n", w.Label, formatQuotaUsage(w.Usage.Requests, w.Requests), formatQuotaUsage(w.Usage.Tokens, w.Tokens)))
+        }
+    }
+    stateMu.Unlock()
+
+    sb.WriteString("──────────────\n")
+    sb.WriteString("额度按自然日、周（周一开始）和月自动重置")
+    sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, sb.String()), messageThreadID(message))
+}
+
+func formatQuotaUsage(used, limit int) string {
+    if limit == 0 {
+        return fmt.Sprintf("%d/不限", used)
+    }
+    return fmt.Sprintf("%d/%d", used, limit)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "unicode/utf16"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type ReasoningConfig struct {
+    Mode     string `yaml:"mode"`
+    MaxChars int    `yaml:"max_chars"`
+}
+
+const (
+    reasoningShow = "show"
+    reasoningHide = "hide"
+    reasoningFile = "file"
+)
+
+var reasoningModeLabels = map[string]string{
+    reasoningShow: "💭 折叠显示",
+    reasoningHide: "🙈 隐藏",
+    reasoningFile: "📄 文件发送",
+}
+
+// 部分模型把思考过程放在回答开头的 <think> 标签中，未闭合时视为全部是思考内容
+var thinkTagRegex = regexp.MustCompile(`(?s)^\s*<think>(.*?)(?:</think>|$)\s*`)
+
+func reasoningMaxChars() int {
+    if config.Reasoning.MaxChars > 0 {
+        return config.Reasoning.MaxChars
+    }
+    return 3000
+}
+
+func validReasoningMode(mode string) bool {
+    _, ok := reasoningModeLabels[mode]
+    return ok
+}
+
+// reasoningMode 返回聊天的思考过程显示方式，未设置时使用配置默认值
+func reasoningMode(chatID int64) string {
+    mode := config.Reasoning.Mode
+    readChatSettings(chatID, func(settings *ChatSettings) {
+        if settings.ReasoningMode != "" {
+            mode = settings.ReasoningMode
+        }
+    })
+    if !validReasoningMode(mode) {
+        return reasoningShow
+    }
+    return mode
+}
+
+// splitReasoning 从响应中分离思考过程和正式回答
+func splitReasoning(choice OpenAIChoice) (string, string) {
+    reasoning := choice.Message.ReasoningContent
+    if reasoning == "" {
+        reasoning = choice.Message.Reasoning
+    }
+    content := choice.Message.Content
+    if loc := thinkTagRegex.FindStringSubmatchIndex(content); loc != nil {
+        think := content[loc[2]:loc[3]]
+        if reasoning != "" {
+            reasoning += "\n\n"
+        }
+        reasoning += think
+        content = content[loc[1]:]
+    }
+    return strings.TrimSpace(reasoning), content
+}
+
+// addReasoning 累加多轮工具调用中产生的思考过程
+func (r *CompletionResult) addReasoning(reasoning string) {
+    if reasoning == "" {
+        return
+    }
+    if r.Reasoning != "" {
+        r.Reasoning += "\n\n"
+    }
+    r.Reasoning += reasoning
+}
+
+// formatReasoning 把思考过程格式化为 MarkdownV2 可折叠引用，过长时截断
+func formatReasoning(reasoning string) string {
+    if reasoning == "" {
+        return ""
+    }
+    lines := append([]string{"💭 思考过程"}, strings.Split(truncateRunes(reasoning, reasoningMaxChars()), "\n")...)
+
+    var sb strings.Builder
+    for i, line := range lines {
+        if i == 0 {
+            sb.WriteString("**>")
+        } else {
+            sb.WriteString(">")
+        }
+        sb.WriteString(escapeMarkdownV2(line))
+        if i < len(lines)-1 {
+            sb.WriteString("\n")
+        }
+    }
+    sb.WriteString("||\n\n")
+    return sb.String()
+}
+
+// messageTooLong 判断消息是否超过 Telegram 的长度上限；按 UTF-16 计算且包含转义字符，结果偏保守
+func messageTooLong(text string) bool {
+    return len(utf16.Encode([]rune(text))) > maxMessageLength
+}
+
+// sendReasoningFile 以文档形式发送完整的思考过程
+func sendReasoningFile(bot *tgbotapi.BotAPI, chatID int64, threadID, replyTo int, reasoning string) {
+    doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: "reasoning.md", Bytes: []byte(reasoning)})
+    doc.Caption = "💭 思考过程"
+    doc.ReplyToMessageID = replyTo
+    doc.AllowSendingWithoutReply = true
+    if err := sendDocumentThreaded(bot, doc, threadID); err != nil {
+        logEvent("SendReasoningFileError", err)
+    }
+}
+
+// handleReasoningCommand 处理 /reasoning：/reasoning show|hide|file 直接设置，无参数时显示按钮
+func handleReasoningCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    chatID := message.Chat.ID
+    mode := strings.TrimSpace(message.CommandArguments())
+    if mode != "" {
+        if !validReasoningMode(mode) {
+            sendThreaded(bot, tgbotapi.NewMessage(chatID, "用法：/reasoning show|hide|file"), messageThreadID(message))
+            return
+        }
+        setReasoningMode(chatID, mode)
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, "✅ 思考过程显示方式：" + reasoningModeLabels[mode]), messageThreadID(message))
+        return
+    }
+
+    msg := tgbotapi.NewMessage(chatID, "💭 思考过程显示方式，当前："+reasoningModeLabels[reasoningMode(chatID)])
+    msg.ReplyMarkup = reasoningKeyboard()
+    if _, err := sendThreaded(bot, msg, messageThreadID(message)); err != nil {
+        logEvent("SendReasoningMenuError", err)
+    }
+}
+
+func reasoningKeyboard() tgbotapi.InlineKeyboardMarkup {
+    var row []tgbotapi.InlineKeyboardButton
+    for _, mode := range []string{reasoningShow, reasoningHide, reasoningFile} {
+        row = append(row, tgbotapi.NewInlineKeyboardButtonData(reasoningModeLabels[mode], "reasoning:"+mode))
+    }
+    return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+func setReasoningMode(chatID int64, mode string) {
+    updateChatSettings(chatID, func(settings *ChatSettings) {
+        settings.ReasoningMode = mode
+    })
+    logEvent("ReasoningModeSet", map[string]interface{}{
+        "chatID": chatID,
+        "mode":   mode,
+    })
+}
+
+func handleReasoningCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    chatID := query.Message.Chat.ID
+    mode := strings.TrimPrefix(query.Data, "reasoning:")
+    if !validReasoningMode(mode) {
+        bot.Request(tgbotapi.NewCallback(query.ID, "无效选项"))
+        return
+    }
+    setReasoningMode(chatID, mode)
+
+    edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, fmt.Sprintf("💭 思考过程显示方式，当前：%s", reasoningModeLabels[mode]))
+    markup := reasoningKeyboard()
+    edit.ReplyMarkup = &markup
+    if _, err := bot.Send(edit); err != nil {
+        logEvent("EditReasoningMenuError", err)
+    }
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, "已设置为"+reasoningModeLabels[mode])); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
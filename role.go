@@ -0,0 +1,188 @@
+package main
+
+import (
+    "fmt"
+    "path"
+    "strings"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// RoleConfig 定义一个角色可以使用的模型、命令和功能，列表为空表示不限制
+type RoleConfig struct {
+    Name               string   `yaml:"name"`
+    Models             []string `yaml:"models"`               // 支持通配符，如 "gpt-4o*"
+    DefaultModel       string   `yaml:"default_model"`        // 当前模型不在允许范围内时改用的模型
+    Commands           []string `yaml:"commands"`             // 不带斜杠的命令名，"tools" 表示允许模型调用工具
+    MaxContextMessages int      `yaml:"max_context_messages"` // 每次请求最多带上的历史消息条数
+    DisableUploads     bool     `yaml:"disable_uploads"`
+}
+
+// adminRoleName 是管理员使用的角色名，未定义时管理员不受限制
+const adminRoleName = "admin"
+
+func findRole(name string) *RoleConfig {
+    for i := range config.Roles {
+        if config.Roles[i].Name == name {
+            return &config.Roles[i]
+        }
+    }
+    return nil
+}
+
+// userRole 返回用户的角色：管理员为 admin，其次是访问列表中记录的角色，最后是 default_role；
+// 没有配置角色时返回 nil，表示不限制
+func userRole(userID int64) *RoleConfig {
+    if len(config.Roles) == 0 {
+        return nil
+    }
+    if isAdmin(userID) {
+        return findRole(adminRoleName)
+    }
+    stateMu.Lock()
+    var name string
+    if entry := accessLocked().Users[chatKey(userID)]; entry != nil {
+        name = entry.Role
+    }
+    stateMu.Unlock()
+    if role := findRole(name); role != nil {
+        return role
+    }
+    return findRole(config.DefaultRole)
+}
+
+// defaultRole 返回没有具体用户的请求（如频道帖子）使用的角色
+func defaultRole() *RoleConfig {
+    return findRole(config.DefaultRole)
+}
+
+// messageRole 返回消息发送者的角色
+func messageRole(message *tgbotapi.Message) *RoleConfig {
+    if message.From == nil {
+        return nil
+    }
+    return userRole(message.From.ID)
+}
+
+func (r *RoleConfig) label() string {
+    if r == nil {
+        return "不限"
+    }
+    return r.Name
+}
+
+func (r *RoleConfig) allowsModel(model string) bool {
+    if r == nil || len(r.Models) == 0 {
+        return true
+    }
+    model = strings.ToLower(model)
+    for _, pattern := range r.Models {
+        if ok, _ := path.Match(strings.ToLower(pattern), model); ok {
+            return true
+        }
+    }
+    return false
+}
+
+func (r *RoleConfig) allowsCommand(command string) bool {
+    if r == nil || len(r.Commands) == 0 {
+        return true
+    }
+    for _, c := range r.Commands {
+        if strings.TrimPrefix(c, "/") == command {
+            return true
+        }
+    }
+    return false
+}
+
+func (r *RoleConfig) allowsUploads() bool {
+    return r == nil || !r.DisableUploads
+}
+
+// filterModels 只保留角色可以使用的模型
+func (r *RoleConfig) filterModels(models []OpenAIModel) []OpenAIModel {
+    if r == nil || len(r.Models) == 0 {
+        return models
+    }
+    var allowed []OpenAIModel
+    for _, model := range models {
+        if r.allowsModel(model.ID) {
+            allowed = append(allowed, model)
+        }
+    }
+    return allowed
+}
+
+// resolveModel 返回角色实际使用的模型：当前模型不允许时改用 default_model，仍不可用时返回 false
+func (r *RoleConfig) resolveModel(model string) (string, bool) {
+    if r.allowsModel(model) {
+        return model, true
+    }
+    if r.DefaultModel != "" && r.allowsModel(r.DefaultModel) {
+        return r.DefaultModel, true
+    }
+    return "", false
+}
+
+// roleModel 返回消息发送者的角色实际使用的模型，角色不能使用时告知用户并返回 false
+func roleModel(bot *tgbotapi.BotAPI, message *tgbotapi.Message, role *RoleConfig, model string) (string, bool) {
+    resolved, ok := role.resolveModel(model)
+    if !ok {
+        text := fmt.Sprintf("你的角色（%s）不能使用当前模型 %s，请用 /models 切换", role.label(), model)
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, text), messageThreadID(message))
+    }
+    return resolved, ok
+}
+
+// trimContext 只保留最近 limit 条历史消息，系统提示词始终保留，并保证上下文从用户消息开始
+func trimContext(messages []Message, limit int) []Message {
+    if limit <= 0 {
+        return messages
+    }
+    var system, history []Message
+    for i, m := range messages {
+        if m.Role != "system" {
+            system, history = messages[:i], messages[i:]
+            break
+        }
+    }
+    if len(history) <= limit {
+        return messages
+    }
+    history = history[len(history)-limit:]
+    for len(history) > 1 && history[0].Role != "user" {
+        history = history[1:]
+    }
+    return append(append([]Message{}, system...), history...)
+}
+
+// hasUpload 判断消息是否带有图片或文件
+func hasUpload(message *tgbotapi.Message) bool {
+    return len(message.Photo) > 0 || message.Document != nil || message.Audio != nil ||
+        message.Video != nil || message.Voice != nil || message.VideoNote != nil
+}
+
+// commandDenied 判断角色能否使用命令，不能时告知用户；/start 和管理员命令不受角色限制
+func commandDenied(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+    command := message.Command()
+    if command == "start" {
+        return false
+    }
+    for _, c := range adminBotCommands {
+        if c.Command == command {
+            return false
+        }
+    }
+    role := messageRole(message)
+    if role.allowsCommand(command) {
+        return false
+    }
+    logEvent("CommandDeniedByRole", map[string]interface{}{
+        "command": command,
+        "role":    role.label(),
+    })
+    text := fmt.Sprintf("你的角色（%s）不能使用 /%s", role.label(), command)
+    sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, text), messageThreadID(message))
+    return true
+}
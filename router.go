@@ -0,0 +1,228 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// autoModelID 是出现在模型列表中的自动路由伪模型
+const autoModelID = "auto"
+
+type AutoRouteConfig struct {
+    Enabled      bool             `yaml:"enabled"`
+    DefaultModel string           `yaml:"default_model"`
+    Rules        []RouteRule      `yaml:"rules"`
+    Classifier   ClassifierConfig `yaml:"classifier"`
+}
+
+// RouteRule 中设置的条件需全部满足，按顺序取第一条匹配的规则
+type RouteRule struct {
+    Name      string   `yaml:"name"`
+    Model     string   `yaml:"model"`
+    MinLength int      `yaml:"min_length"`
+    MaxLength int      `yaml:"max_length"`
+    HasCode   *bool    `yaml:"has_code"`
+    HasImage  *bool    `yaml:"has_image"`
+    Keywords  []string `yaml:"keywords"`
+    Languages []string `yaml:"languages"`
+}
+
+// ClassifierConfig 在没有规则匹配时调用便宜的模型给消息打标签，再按标签选择模型
+type ClassifierConfig struct {
+    Model  string            `yaml:"model"`
+    Prompt string            `yaml:"prompt"`
+    Routes map[string]string `yaml:"routes"`
+}
+
+var codeRegex = regexp.MustCompile("(?m)```|^\\s*(func|def|class|import|package|public|private|#include|SELECT|const|let|var)\\s|[;{}]\\s*$")
+
+// routeModel 为自动路由的请求选择实际模型，返回模型和原因
+func routeModel(req completionRequest) (string, string) {
+    text := lastUserContent(req.Messages)
+    for _, rule := range config.AutoRoute.Rules {
+        if rule.Model == "" {
+            continue
+        }
+        if conditions, ok := matchRouteRule(rule, text, req.HasImage); ok {
+            name := rule.Name
+            if name == "" {
+                name = rule.Model
+            }
+            reason := "规则「" + name + "」"
+            if len(conditions) > 0 {
+                reason += "：" + strings.Join(conditions, "，")
+            }
+            return rule.Model, reason
+        }
+    }
+
+    if config.AutoRoute.Classifier.Model != "" && len(config.AutoRoute.Classifier.Routes) > 0 {
+        if label, err := classifyMessage(text); err != nil {
+            logEvent("RouteClassifierError", err.Error())
+        } else if model := config.AutoRoute.Classifier.Routes[label]; model != "" {
+            return model, "分类器判定为「" + label + "」"
+        }
+    }
+
+    model := config.AutoRoute.DefaultModel
+    if model == "" || model == autoModelID {
+        model = currentModel
+    }
+    if models := getOpenAIModels(); model == autoModelID && len(models) > 0 {
+        model = models[0].ID
+    }
+    return model, "无匹配规则，使用默认模型"
+}
+
+func lastUserContent(messages []Message) string {
+    for i := len(messages) - 1; i >= 0; i-- {
+        if messages[i].Role == "user" {
+            return messages[i].Content
+        }
+    }
+    return ""
+}
+
+// matchRouteRule 判断消息是否满足规则，返回满足的条件描述
+func matchRouteRule(rule RouteRule, text string, hasImage bool) ([]string, bool) {
+    var conditions []string
+    length := len([]rune(text))
+    if rule.MinLength > 0 {
+        if length < rule.MinLength {
+            return nil, false
+        }
+        conditions = append(conditions, fmt.Sprintf("长度 %d ≥ %d", length, rule.MinLength))
+    }
+    if rule.MaxLength > 0 {
+        if length > rule.MaxLength {
+            return nil, false
+        }
+        conditions = append(conditions, fmt.Sprintf("长度 %d ≤ %d", length, rule.MaxLength))
+    }
+    if rule.HasCode != nil {
+        if codeRegex.MatchString(text) != *rule.HasCode {
+            return nil, false
+        }
+        if *rule.HasCode {
+            conditions = append(conditions, "包含代码")
+        } else {
+            conditions = append(conditions, "不含代码")
+        }
+    }
+    if rule.HasImage != nil {
+        if hasImage != *rule.HasImage {
+            return nil, false
+        }
+        if hasImage {
+            conditions = append(conditions, "包含图片")
+        } else {
+            conditions = append(conditions, "不含图片")
+        }
+    }
+    if len(rule.Keywords) > 0 {
+        lower := strings.ToLower(text)
+        matched := ""
+        for _, keyword := range rule.Keywords {
+            if keyword != "" && strings.Contains(lower, strings.ToLower(keyword)) {
+                matched = keyword
+                break
+            }
+        }
+        if matched == "" {
+            return nil, false
+        }
+        conditions = append(conditions, "关键词「"+matched+"」")
+    }
+    if len(rule.Languages) > 0 {
+        lang := detectLanguage(text)
+        matched := false
+        for _, l := range rule.Languages {
+            if strings.EqualFold(l, lang) {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            return nil, false
+        }
+        conditions = append(conditions, "语言 "+lang)
+    }
+    return conditions, true
+}
+
+// detectLanguage 按文字系统粗略判断消息的主要语言
+func detectLanguage(text string) string {
+    counts := map[string]int{}
+    for _, r := range text {
+        switch {
+        case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+            // 日文中也有大量汉字，假名权重加倍
+            counts["ja"] += 2
+        case unicode.Is(unicode.Han, r):
+            counts["zh"]++
+        case unicode.Is(unicode.Hangul, r):
+            counts["ko"]++
+        case unicode.Is(unicode.Cyrillic, r):
+            counts["ru"]++
+        case unicode.Is(unicode.Arabic, r):
+            counts["ar"]++
+        case unicode.Is(unicode.Latin, r):
+            counts["en"]++
+        }
+    }
+    best, bestCount := "", 0
+    for _, lang := range []string{"zh", "ja", "ko", "ru", "ar", "en"} {
+        if counts[lang] > bestCount {
+            best, bestCount = lang, counts[lang]
+        }
+    }
+    if best == "" {
+        return "en"
+    }
+    return best
+}
+
+// classifyMessage 让分类模型从配置的标签中选出一个
+func classifyMessage(text string) (string, error) {
+    labels := make([]string, 0, len(config.AutoRoute.Classifier.Routes))
+    for label := range config.AutoRoute.Classifier.Routes {
+        labels = append(labels, label)
+    }
+    sort.Strings(labels)
+
+    prompt := config.AutoRoute.Classifier.Prompt
+    if prompt == "" {
+        prompt = "你是一个请求分类器。判断用户消息的难度和类型，只输出以下标签之一，不要输出其他内容："
+    }
+    prompt += "\n" + strings.Join(labels, ", ")
+
+    messages := []Message{
+        {Role: "system", Content: prompt, Time: time.Now()},
+        {Role: "user", Content: truncateRunes(text, 2000), Time: time.Now()},
+    }
+    resp, err := postChatCompletion(config.AutoRoute.Classifier.Model, GenerationParams{}, messages, nil)
+    if err != nil {
+        return "", err
+    }
+    if len(resp.Choices) == 0 {
+        return "", fmt.Errorf("classifier returned no choices")
+    }
+    _, reply := splitReasoning(resp.Choices[0])
+    reply = strings.ToLower(strings.TrimSpace(reply))
+    // 优先完全匹配，其次取回答中出现的第一个标签
+    for _, label := range labels {
+        if reply == strings.ToLower(label) {
+            return label, nil
+        }
+    }
+    for _, label := range labels {
+        if strings.Contains(reply, strings.ToLower(label)) {
+            return label, nil
+        }
+    }
+    return "", fmt.Errorf("unrecognized classifier reply: %s", reply)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type SearchConfig struct {
+    Enabled        bool              `yaml:"enabled"`
+    URL            string            `yaml:"url"`
+    Headers        map[string]string `yaml:"headers"`
+    Language       string            `yaml:"language"`
+    Categories     string            `yaml:"categories"`
+    MaxResults     int               `yaml:"max_results"`
+    TimeoutSeconds int               `yaml:"timeout_seconds"`
+    AnswerPrompt   string            `yaml:"answer_prompt"`
+}
+
+type searchResult struct {
+    Title   string `json:"title"`
+    URL     string `json:"url"`
+    Content string `json:"content"`
+    Engine  string `json:"engine"`
+}
+
+const defaultSearchAnswerPrompt = "你是一个联网搜索助手。请根据提供的搜索结果回答用户的问题，" +
+    "引用信息时在句末用 [编号] 标注来源，例如 [1]、[2]。如果搜索结果不足以回答，请如实说明。"
+
+var citationRegex = regexp.MustCompile(`\[(\d{1,2})\]`)
+
+func searchMaxResults() int {
+    if config.Search.MaxResults > 0 {
+        return config.Search.MaxResults
+    }
+    return 5
+}
+
+// webSearch 调用 SearxNG 兼容的 JSON 接口
+func webSearch(query string) ([]searchResult, error) {
+    if config.Search.URL == "" {
+        return nil, fmt.Errorf("未配置搜索接口")
+    }
+    params := url.Values{}
+    params.Set("q", query)
+    params.Set("format", "json")
+    if config.Search.Language != "" {
+        params.Set("language", config.Search.Language)
+    }
+    if config.Search.Categories != "" {
+        params.Set("categories", config.Search.Categories)
+    }
+    endpoint := strings.TrimRight(config.Search.URL, "/") + "/search?" + params.Encode()
+
+    req, err := http.NewRequest("GET", endpoint, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/json")
+    for k, v := range config.Search.Headers {
+        req.Header.Set(k, v)
+    }
+
+    timeout := 15 * time.Second
+    if config.Search.TimeoutSeconds > 0 {
+        timeout = time.Duration(config.Search.TimeoutSeconds) * time.Second
+    }
+    client := &http.Client{Timeout: timeout}
+    resp, err := client.Do(req)
+    if err != nil {
+        logEvent("SearchRequestError", err.Error())
+        return nil, fmt.Errorf("搜索请求失败: %v", err)
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, fmt.Errorf("读取搜索结果失败: %v", err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("搜索接口返回 HTTP %d", resp.StatusCode)
+    }
+
+    var searchResp struct {
+        Results []searchResult `json:"results"`
+    }
+    if err := json.Unmarshal(body, &searchResp); err != nil {
+        logEvent("UnmarshalSearchError", err.Error())
+        return nil, fmt.Errorf("解析搜索结果失败")
+    }
+
+    results := searchResp.Results
+    if len(results) > searchMaxResults() {
+        results = results[:searchMaxResults()]
+    }
+    logEvent("SearchCompleted", map[string]interface{}{
+        "query":   query,
+        "results": len(results),
+    })
+    return results, nil
+}
+
+// formatSearchContext 把搜索结果整理成带编号的上下文
+func formatSearchContext(results []searchResult) string {
+    var sb strings.Builder
+    for i, r := range results {
+        sb.WriteString(fmt.Sprintf("[%d] %s\n%s\n%s\n\n", i+1, r.Title, r.URL, truncateRunes(strings.TrimSpace(r.Content), 500)))
+    }
+    return strings.TrimSpace(sb.String())
+}
+
+// linkCitations 把回答中的 [n] 标注转换成指向来源的 Markdown 链接，已经是链接的保持不变
+func linkCitations(answer string, results []searchResult) string {
+    var sb strings.Builder
+    last := 0
+    for _, loc := range citationRegex.FindAllStringSubmatchIndex(answer, -1) {
+        if loc[1] < len(answer) && answer[loc[1]] == '(' {
+            continue
+        }
+        n, _ := strconv.Atoi(answer[loc[2]:loc[3]])
+        if n < 1 || n > len(results) {
+            continue
+        }
+        sb.WriteString(answer[last:loc[0]])
+        sb.WriteString(fmt.Sprintf("[[%d]](%s)", n, markdownURL(results[n-1].URL)))
+        last = loc[1]
+    }
+    sb.WriteString(answer[last:])
+    return sb.String()
+}
+
+// markdownURL 编码链接中的括号，避免破坏 Markdown 链接语法
+func markdownURL(u string) string {
+    return strings.NewReplacer("(", "%28", ")", "%29", " ", "%20").Replace(u)
+}
+
+// formatSources 生成 Markdown 格式的编号来源列表
+func formatSources(results []searchResult) string {
+    var sb strings.Builder
+    sb.WriteString("\n\n**来源**\n")
+    for i, r := range results {
+        title := strings.TrimSpace(r.Title)
+        if title == "" {
+            title = r.URL
+        }
+        title = strings.NewReplacer("[", "(", "]", ")").Replace(title)
+        sb.WriteString(fmt.Sprintf("%d. [%s](%s)\n", i+1, title, markdownURL(r.URL)))
+    }
+    return sb.String()
+}
+
+func registerSearchTool() {
+    registerTool(&Tool{
+        Name:        "web_search",
+        Description: "Search the web for up-to-date information. Returns numbered results with title, URL and snippet. Cite results in the answer as markdown links like [1](url).",
+        Parameters: map[string]interface{}{
+            "type": "object",
+            "properties": map[string]interface{}{
+                "query": map[string]interface{}{
+                    "type":        "string",
+                    "description": "The search query",
+                },
+            },
+            "required": []string{"query"},
+        },
+        Handler: func(raw json.RawMessage) (string, error) {
+            var args struct {
+                Query string `json:"query"`
+            }
+            if err := json.Unmarshal(raw, &args); err != nil {
+                return "", fmt.Errorf("invalid arguments: %v", err)
+            }
+            if strings.TrimSpace(args.Query) == "" {
+                return "", fmt.Errorf("query must not be empty")
+            }
+            results, err := webSearch(args.Query)
+            if err != nil {
+                return "", err
+            }
+            if len(results) == 0 {
+                return "No results found.", nil
+            }
+            return formatSearchContext(results), nil
+        },
+    })
+}
+
+// handleSearchCommand 处理 /search 命令：搜索、让模型基于结果作答并附上编号来源
+func handleSearchCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    query := strings.TrimSpace(message.CommandArguments())
+    if query == "" {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, "用法：/search <搜索内容>"), messageThreadID(message))
+        return
+    }
+    if !config.Search.Enabled {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, "搜索功能未启用"), messageThreadID(message))
+        return
+    }
+
+    role := messageRole(message)
+    model, ok := roleModel(bot, message, role, sessionModel(sessionKeyFor(message)))
+    if !ok || !consumeQuota(bot, message) {
+        return
+    }
+
+    start := time.Now()
+    results, err := webSearch(query)
+    if err != nil {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("搜索失败：%v", err)), messageThreadID(message))
+        return
+    }
+    if len(results) == 0 {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, "没有找到相关结果"), messageThreadID(message))
+        return
+    }
+
+    prompt := config.Search.AnswerPrompt
+    if prompt == "" {
+        prompt = defaultSearchAnswerPrompt
+    }
+    messages := []Message{
+        {Role: "system", Content: prompt, Time: time.Now()},
+        {Role: "user", Content: fmt.Sprintf("问题：%s\n\n搜索结果：\n%s", query, formatSearchContext(results)), Time: time.Now()},
+    }
+    result, err := callOpenAIWithRetry(completionRequest{ChatID: message.Chat.ID, Model: model, Messages: messages, NoTools: true, Role: role})
+    if err != nil {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("抱歉，生成回答失败：%v", err)), messageThreadID(message))
+        return
+    }
+    recordUsage(bot, message, result)
+
+    answer := linkCitations(result.Content, results) + formatSources(results)
+    header := fmt.Sprintf("🔍 %s\n⏱ %.2f秒\n\n", query, time.Since(start).Seconds())
+    msg := tgbotapi.NewMessage(message.Chat.ID, escapeMarkdownV2(header)+mdToTgmd(answer))
+    msg.ParseMode = "MarkdownV2"
+    msg.DisableWebPagePreview = true
+    if _, err := sendThreaded(bot, msg, messageThreadID(message)); err != nil {
+        logEvent("SendSearchAnswerError", err)
+        plain := tgbotapi.NewMessage(message.Chat.ID, header+answer)
+        plain.DisableWebPagePreview = true
+        sendThreaded(bot, plain, messageThreadID(message))
+    }
+}
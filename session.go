@@ -0,0 +1,387 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Session 是一段独立的对话，拥有自己的历史、模型和系统提示词
+type Session struct {
+    ID              string           `json:"id"`
+    ParentID        string           `json:"parent_id,omitempty"`
+    Title           string           `json:"title"`
+    Model           string           `json:"model"`
+    SystemPrompt    string           `json:"system_prompt"`
+    Persona         string           `json:"persona,omitempty"`
+    Params          GenerationParams `json:"params"`
+    History         []Message        `json:"history"`
+    RemainingRounds int              `json:"remaining_rounds"`
+    InteractionTime time.Time        `json:"interaction_time"`
+    CreatedAt       time.Time        `json:"created_at"`
+    UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+// chatSessions 保存一个聊天下的所有会话
+type chatSessions struct {
+    ActiveID string     `json:"active_id"`
+    NextID   int        `json:"next_id"`
+    Sessions []*Session `json:"sessions"`
+}
+
+const (
+    defaultMaxSessions = 20
+    sessionListLimit   = 10
+    titleMaxRunes      = 20
+)
+
+func maxSessions() int {
+    if config.Sessions.MaxSessions > 0 {
+        return config.Sessions.MaxSessions
+    }
+    return defaultMaxSessions
+}
+
+// sessionKeyFor 返回消息所属的会话存储键
+func sessionKeyFor(message *tgbotapi.Message) string {
+    return sessionKey(message.Chat, messageThreadID(message), message.From)
+}
+
+// chatSessionsLocked 返回会话列表，不存在时创建，调用方需持有 stateMu
+func chatSessionsLocked(key string) *chatSessions {
+    chat, ok := state.Sessions[key]
+    if !ok {
+        chat = &chatSessions{}
+        state.Sessions[key] = chat
+    }
+    return chat
+}
+
+func (c *chatSessions) find(id string) *Session {
+    for _, s := range c.Sessions {
+        if s.ID == id {
+            return s
+        }
+    }
+    return nil
+}
+
+// newSessionLocked 创建并激活新会话，超出数量上限时删除最久未使用的会话
+func (c *chatSessions) newSessionLocked(model, prompt string, params GenerationParams) *Session {
+    c.NextID++
+    now := time.Now()
+    sess := &Session{
+        ID:              strconv.Itoa(c.NextID),
+        Model:           model,
+        SystemPrompt:    prompt,
+        Params:          params,
+        RemainingRounds: config.HistoryLength,
+        InteractionTime: now,
+        CreatedAt:       now,
+        UpdatedAt:       now,
+    }
+    c.Sessions = append(c.Sessions, sess)
+    c.ActiveID = sess.ID
+
+    for len(c.Sessions) > maxSessions() {
+        oldest := -1
+        for i, s := range c.Sessions {
+            if s.ID == c.ActiveID {
+                continue
+            }
+            if oldest < 0 || s.UpdatedAt.Before(c.Sessions[oldest].UpdatedAt) {
+                oldest = i
+            }
+        }
+        if oldest < 0 {
+            break
+        }
+        c.Sessions = append(c.Sessions[:oldest], c.Sessions[oldest+1:]...)
+    }
+    return sess
+}
+
+// activeSessionLocked 返回当前会话，没有时用聊天或话题的默认模型和系统提示词创建，调用方需持有 stateMu
+func activeSessionLocked(key string) *Session {
+    chat := chatSessionsLocked(key)
+    if sess := chat.find(chat.ActiveID); sess != nil {
+        return sess
+    }
+    prompt, model, params := sessionDefaultsLocked(key)
+    return chat.newSessionLocked(model, prompt, params)
+}
+
+// withActiveSession 在锁内操作当前会话并持久化
+func withActiveSession(key string, fn func(sess *Session)) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    fn(activeSessionLocked(key))
+    saveStateLocked()
+}
+
+// withSession 在锁内操作指定会话并持久化，会话已被删除时返回 false
+func withSession(key, id string, fn func(sess *Session)) bool {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    chat, ok := state.Sessions[key]
+    if !ok {
+        return false
+    }
+    sess := chat.find(id)
+    if sess == nil {
+        return false
+    }
+    fn(sess)
+    saveStateLocked()
+    return true
+}
+
+// sessionModel 返回聊天当前会话使用的模型
+func sessionModel(key string) string {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    if chat, ok := state.Sessions[key]; ok {
+        if sess := chat.find(chat.ActiveID); sess != nil && sess.Model != "" {
+            return sess.Model
+        }
+    }
+    return currentModel
+}
+
+// findTurn 按 Telegram 消息 ID 查找历史中的一轮，找不到时返回 -1
+func (s *Session) findTurn(tgMsgID int) int {
+    for i := len(s.History) - 1; i >= 0; i-- {
+        if s.History[i].TgMsgID == tgMsgID {
+            return i
+        }
+    }
+    return -1
+}
+
+// branchOnReply 处理回复旧消息：回复的是会话最新一条回答时直接继续；
+// 否则从该回答处复制上下文创建分支会话并切换过去。返回给用户的提示，未分支时为空
+func branchOnReply(key string, replyToID int) string {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+
+    chat := chatSessionsLocked(key)
+    active := activeSessionLocked(key)
+
+    source, index := active, active.findTurn(replyToID)
+    if index < 0 {
+        source = nil
+        for i := len(chat.Sessions) - 1; i >= 0; i-- {
+            if idx := chat.Sessions[i].findTurn(replyToID); idx >= 0 {
+                source, index = chat.Sessions[i], idx
+                break
+            }
+        }
+    }
+    if source == nil || source.History[index].Role != "assistant" {
+        return ""
+    }
+
+    if index == len(source.History)-1 {
+        if source == active {
+            return ""
+        }
+        // 回复的是另一个会话的最新回答，直接切换回去继续
+        chat.ActiveID = source.ID
+        saveStateLocked()
+        return fmt.Sprintf("🔀 已切换到会话 #%s：%s", source.ID, source.displayTitle())
+    }
+
+    // 分支重新计时，避免复制过来的上下文立即因超过记忆保留时间被清除
+    now := time.Now()
+    history := make([]Message, index+1)
+    copy(history, source.History[:index+1])
+    for i := range history {
+        history[i].Time = now
+    }
+    branch := chat.newSessionLocked(source.Model, source.SystemPrompt, source.Params)
+    branch.Persona = source.Persona
+    branch.ParentID = source.ID
+    branch.History = history
+    branch.Title = truncateRunes("↳ "+source.displayTitle(), titleMaxRunes)
+    saveStateLocked()
+
+    logEvent("SessionBranched", map[string]interface{}{
+        "key":     key,
+        "from":    source.ID,
+        "to":      branch.ID,
+        "atTurn":  index,
+        "replyTo": replyToID,
+    })
+    return fmt.Sprintf("🌿 已从会话 #%s 的这条回答处分支为新会话 #%s", source.ID, branch.ID)
+}
+
+// requestMessages 组装发送给模型的消息：渲染后的系统提示词加上历史记录
+func (s *Session) requestMessages(vars promptVars) []Message {
+    messages := make([]Message, 0, len(s.History)+1)
+    if s.SystemPrompt != "" {
+        vars.Model = s.Model
+        vars.Persona = s.Persona
+        messages = append(messages, Message{Role: "system", Content: renderSystemPrompt(s.SystemPrompt, vars), Time: s.CreatedAt})
+    }
+    return append(messages, s.History...)
+}
+
+// reset 清空历史并重置轮数和计时
+func (s *Session) reset(now time.Time) {
+    s.History = nil
+    s.RemainingRounds = config.HistoryLength
+    s.InteractionTime = now
+}
+
+// pruneExpired 删除超过保留时间的历史消息
+func (s *Session) pruneExpired(now time.Time) {
+    cutoffTime := now.Add(-time.Duration(config.HistoryTimeoutMinutes) * time.Minute)
+    var kept []Message
+    for _, msg := range s.History {
+        if msg.Time.After(cutoffTime) {
+            kept = append(kept, msg)
+        }
+    }
+    s.History = kept
+}
+
+func (s *Session) displayTitle() string {
+    if s.Title != "" {
+        return s.Title
+    }
+    return "新会话"
+}
+
+func fallbackTitle(text string) string {
+    title := strings.Join(strings.Fields(text), " ")
+    return truncateRunes(title, titleMaxRunes)
+}
+
+// generateSessionTitle 根据首轮对话生成会话标题，失败时保留截断的首条消息
+func generateSessionTitle(chatID int64, key, sessionID, model, question, answer string) {
+    if config.Sessions.DisableAITitle {
+        return
+    }
+    prompt := "请为下面这段对话生成一个简短的标题，不超过12个字，只输出标题本身，不要标点和引号。"
+    messages := []Message{
+        {Role: "system", Content: prompt, Time: time.Now()},
+        {Role: "user", Content: fmt.Sprintf("用户：%s\n\n助手：%s", truncateRunes(question, 500), truncateRunes(answer, 500)), Time: time.Now()},
+    }
+    titleModel := config.Sessions.TitleModel
+    if titleModel == "" {
+        titleModel = model
+    }
+    result, err := callOpenAIWithRetry(completionRequest{ChatID: chatID, Model: titleModel, Messages: messages, NoTools: true})
+    if err != nil {
+        logEvent("GenerateTitleError", err.Error())
+        return
+    }
+    title := strings.Trim(strings.TrimSpace(result.Content), "\"'“”《》#*")
+    if title == "" {
+        return
+    }
+    withSession(key, sessionID, func(sess *Session) {
+        sess.Title = truncateRunes(title, titleMaxRunes)
+    })
+}
+
+// startNewSession 处理 /new 命令，新会话沿用当前会话的模型和系统提示词
+func startNewSession(bot *tgbotapi.BotAPI, chatID int64, key string) {
+    var model string
+    stateMu.Lock()
+    current := activeSessionLocked(key)
+    model = current.Model
+    sess := chatSessionsLocked(key).newSessionLocked(current.Model, current.SystemPrompt, current.Params)
+    sess.Persona = current.Persona
+    saveStateLocked()
+    stateMu.Unlock()
+
+    logEvent("SessionCreated", map[string]interface{}{
+        "key":     key,
+        "session": sess.ID,
+    })
+    sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("🆕 已开始新会话 #%s\n⚙️ 模型: %s", sess.ID, model)), keyThreadID(key))
+}
+
+// sendSessionList 以内联键盘列出最近的会话；editMessageID 非零时原地更新
+func sendSessionList(bot *tgbotapi.BotAPI, chatID int64, key string, editMessageID int) {
+    stateMu.Lock()
+    active := activeSessionLocked(key)
+    activeID := active.ID
+    chat := chatSessionsLocked(key)
+    sessions := append([]*Session(nil), chat.Sessions...)
+    type item struct {
+        id, title, model string
+        turns            int
+        updated          time.Time
+    }
+    var items []item
+    for i := len(sessions) - 1; i >= 0 && len(items) < sessionListLimit; i-- {
+        s := sessions[i]
+        items = append(items, item{s.ID, s.displayTitle(), s.Model, len(s.History) / 2, s.UpdatedAt})
+    }
+    stateMu.Unlock()
+
+    var keyboard [][]tgbotapi.InlineKeyboardButton
+    for _, it := range items {
+        prefix := "💬"
+        if it.id == activeID {
+            prefix = "✅"
+        }
+        label := fmt.Sprintf("%s #%s %s · %d轮 · %s", prefix, it.id, it.title, it.turns, it.updated.Format("01-02 15:04"))
+        keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+            tgbotapi.NewInlineKeyboardButtonData(label, "session:"+it.id),
+        ))
+    }
+    text := "📚 会话列表（最近 10 个），点击切换：\n使用 /new 开始新会话"
+
+    if editMessageID != 0 {
+        markup := tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+        edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, editMessageID, text, markup)
+        if _, err := bot.Send(edit); err != nil {
+            logEvent("EditSessionListError", err)
+        }
+        return
+    }
+    msg := tgbotapi.NewMessage(chatID, text)
+    msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+    if _, err := sendThreaded(bot, msg, keyThreadID(key)); err != nil {
+        logEvent("SendSessionListError", err)
+    }
+}
+
+func handleSessionSwitch(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+    chatID := query.Message.Chat.ID
+    key := callbackSessionKey(query)
+    id := strings.TrimPrefix(query.Data, "session:")
+
+    var answer, title, model string
+    var turns int
+    stateMu.Lock()
+    chat := chatSessionsLocked(key)
+    sess := chat.find(id)
+    if sess != nil {
+        chat.ActiveID = sess.ID
+        title, model, turns = sess.displayTitle(), sess.Model, len(sess.History)/2
+        saveStateLocked()
+    }
+    stateMu.Unlock()
+
+    if sess == nil {
+        answer = "会话不存在或已被清理"
+    } else {
+        answer = "已切换到会话 #" + id
+        logEvent("SessionSwitched", map[string]interface{}{
+            "key":     key,
+            "session": id,
+        })
+        sendSessionList(bot, chatID, key, query.Message.MessageID)
+        sendThreaded(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("🔀 已切换到会话 #%s：%s\n⚙️ 模型: %s\n💬 已有 %d 轮对话", id, title, model, turns)), keyThreadID(key))
+    }
+    if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+        logEvent("AnswerCallbackQueryError", err)
+    }
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+)
+
+const defaultDataFile = "/app/config/data.json"
+
+// botState 是需要持久化的运行时数据，保存在 data_file 指定的 JSON 文件中
+type botState struct {
+    Chats    map[string]*ChatSettings `json:"chats"`
+    Sessions map[string]*chatSessions `json:"sessions"`
+    Access   *AccessState             `json:"access,omitempty"`
+    Usage    map[string]*usageCounter `json:"usage,omitempty"`
+}
+
+// ChatSettings 是每个聊天独立的设置
+type ChatSettings struct {
+    MCPServers     map[string]bool           `json:"mcp_servers,omitempty"`
+    SystemPrompt   *string                   `json:"system_prompt,omitempty"`
+    Timezone       string                    `json:"timezone,omitempty"`
+    Params         *GenerationParams         `json:"params,omitempty"`
+    ReasoningMode  string                    `json:"reasoning_mode,omitempty"`
+    FavoriteModels []string                  `json:"favorite_models,omitempty"`
+    Topics         map[string]*TopicSettings `json:"topics,omitempty"`
+}
+
+var (
+    stateMu sync.Mutex
+    state   = &botState{Chats: map[string]*ChatSettings{}, Sessions: map[string]*chatSessions{}}
+)
+
+func dataFilePath() string {
+    if config.DataFile != "" {
+        return config.DataFile
+    }
+    return defaultDataFile
+}
+
+func loadState() {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+
+    data, err := ioutil.ReadFile(dataFilePath())
+    if err != nil {
+        if !os.IsNotExist(err) {
+            logEvent("LoadStateError", err.Error())
+        }
+        return
+    }
+    loaded := &botState{}
+    if err := json.Unmarshal(data, loaded); err != nil {
+        logEvent("UnmarshalStateError", err.Error())
+        return
+    }
+    if loaded.Chats == nil {
+        loaded.Chats = map[string]*ChatSettings{}
+    }
+    if loaded.Sessions == nil {
+        loaded.Sessions = map[string]*chatSessions{}
+    }
+    state = loaded
+}
+
+// saveStateLocked 将状态写入磁盘，调用方需持有 stateMu
+func saveStateLocked() {
+    data, err := json.MarshalIndent(state, "", "  ")
+    if err != nil {
+        logEvent("MarshalStateError", err.Error())
+        return
+    }
+    path := dataFilePath()
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        logEvent("SaveStateError", err.Error())
+        return
+    }
+    // 先写临时文件再重命名，避免写到一半时文件损坏
+    tmp := path + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+        logEvent("SaveStateError", err.Error())
+        return
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        logEvent("SaveStateError", err.Error())
+    }
+}
+
+func chatKey(chatID int64) string {
+    return strconv.FormatInt(chatID, 10)
+}
+
+// chatSettingsLocked 返回聊天设置，不存在时创建，调用方需持有 stateMu
+func chatSettingsLocked(chatID int64) *ChatSettings {
+    key := chatKey(chatID)
+    settings, ok := state.Chats[key]
+    if !ok {
+        settings = &ChatSettings{}
+        state.Chats[key] = settings
+    }
+    return settings
+}
+
+// updateChatSettings 在锁内修改聊天设置并持久化
+func updateChatSettings(chatID int64, fn func(settings *ChatSettings)) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    fn(chatSettingsLocked(chatID))
+    saveStateLocked()
+}
+
+// readChatSettings 在锁内读取聊天设置，不会创建新条目
+func readChatSettings(chatID int64, fn func(settings *ChatSettings)) {
+    stateMu.Lock()
+    defer stateMu.Unlock()
+    settings, ok := state.Chats[chatKey(chatID)]
+    if !ok {
+        settings = &ChatSettings{}
+    }
+    fn(settings)
+}
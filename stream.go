@@ -0,0 +1,123 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "io"
+    "sort"
+    "strings"
+)
+
+// openAIStreamChunk 是流式响应中的一个增量片段
+type openAIStreamChunk struct {
+    Choices []struct {
+        Index int `json:"index"`
+        Delta struct {
+            Content          string `json:"content"`
+            ReasoningContent string `json:"reasoning_content"`
+            Reasoning        string `json:"reasoning"`
+            ToolCalls        []struct {
+                Index    int    `json:"index"`
+                ID       string `json:"id"`
+                Type     string `json:"type"`
+                Function struct {
+                    Name      string `json:"name"`
+                    Arguments string `json:"arguments"`
+                } `json:"function"`
+            } `json:"tool_calls"`
+        } `json:"delta"`
+        FinishReason string `json:"finish_reason"`
+    } `json:"choices"`
+    Usage *OpenAIUsage `json:"usage"`
+}
+
+// readChatCompletionStream 把 SSE 流式响应拼装成与非流式相同的 OpenAIResponse
+func readChatCompletionStream(body io.Reader) (*OpenAIResponse, error) {
+    var content, reasoningContent, reasoning strings.Builder
+    var finishReason string
+    var usage *OpenAIUsage
+    toolCalls := map[int]*ToolCall{}
+    received := false
+
+    reader := bufio.NewReader(body)
+    for {
+        line, err := reader.ReadString('\n')
+        trimmed := strings.TrimSpace(line)
+        if strings.HasPrefix(trimmed, "data:") {
+            data := strings.TrimSpace(strings.TrimPrefix(trimmed, "data:"))
+            if data == "[DONE]" {
+                break
+            }
+            var chunk openAIStreamChunk
+            if jerr := json.Unmarshal([]byte(data), &chunk); jerr != nil {
+                logEvent("UnmarshalStreamChunkError", map[string]interface{}{
+                    "error": jerr.Error(),
+                    "data":  data,
+                })
+            } else {
+                received = true
+                if chunk.Usage != nil {
+                    usage = chunk.Usage
+                }
+                for _, choice := range chunk.Choices {
+                    if choice.Index != 0 {
+                        continue
+                    }
+                    content.WriteString(choice.Delta.Content)
+                    reasoningContent.WriteString(choice.Delta.ReasoningContent)
+                    reasoning.WriteString(choice.Delta.Reasoning)
+                    if choice.FinishReason != "" {
+                        finishReason = choice.FinishReason
+                    }
+                    for _, delta := range choice.Delta.ToolCalls {
+                        call, ok := toolCalls[delta.Index]
+                        if !ok {
+                            call = &ToolCall{Type: "function"}
+                            toolCalls[delta.Index] = call
+                        }
+                        if delta.ID != "" {
+                            call.ID = delta.ID
+                        }
+                        if delta.Type != "" {
+                            call.Type = delta.Type
+                        }
+                        call.Function.Name += delta.Function.Name
+                        call.Function.Arguments += delta.Function.Arguments
+                    }
+                }
+            }
+        }
+        if err != nil {
+            if err == io.EOF {
+                break
+            }
+            logEvent("ReadStreamError", err)
+            return nil, &upstreamError{msg: "Error reading stream"}
+        }
+    }
+    if !received {
+        return nil, &upstreamError{msg: "Empty stream response"}
+    }
+
+    var choice OpenAIChoice
+    choice.Message.Content = content.String()
+    choice.Message.ReasoningContent = reasoningContent.String()
+    choice.Message.Reasoning = reasoning.String()
+    choice.FinishReason = finishReason
+    indexes := make([]int, 0, len(toolCalls))
+    for index := range toolCalls {
+        indexes = append(indexes, index)
+    }
+    sort.Ints(indexes)
+    for _, index := range indexes {
+        choice.Message.ToolCalls = append(choice.Message.ToolCalls, *toolCalls[index])
+    }
+
+    logEvent("StreamResponseAssembled", map[string]interface{}{
+        "contentLength":   content.Len(),
+        "reasoningLength": reasoningContent.Len() + reasoning.Len(),
+        "toolCalls":       len(choice.Message.ToolCalls),
+        "finishReason":    finishReason,
+    })
+    return &OpenAIResponse{Choices: []OpenAIChoice{choice}, Usage: usage}, nil
+}
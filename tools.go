@@ -0,0 +1,211 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "sync"
+)
+
+// 发送给模型的工具定义（OpenAI function calling 格式）
+type ToolDefinition struct {
+    Type     string       `json:"type"`
+    Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+    Name        string                 `json:"name"`
+    Description string                 `json:"description"`
+    Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// 模型返回的工具调用
+type ToolCall struct {
+    ID       string `json:"id"`
+    Type     string `json:"type"`
+    Function struct {
+        Name      string `json:"name"`
+        Arguments string `json:"arguments"`
+    } `json:"function"`
+}
+
+// Tool 是注册到机器人中的一个可调用工具，Server 非空表示来自该 MCP 服务器
+type Tool struct {
+    Name        string
+    Description string
+    Parameters  map[string]interface{}
+    Server      string
+    Handler     func(args json.RawMessage) (string, error)
+}
+
+// 一次工具调用的记录，会以可折叠引用的形式显示在回复中
+type toolNote struct {
+    Name      string
+    Arguments string
+    Result    string
+    Failed    bool
+}
+
+var (
+    toolsMu       sync.RWMutex
+    toolRegistry  = map[string]*Tool{}
+    toolOrder     []string
+)
+
+const (
+    defaultToolMaxSteps   = 5
+    maxToolResultLength   = 8000
+    maxToolNoteLength     = 200
+)
+
+func registerTool(tool *Tool) {
+    toolsMu.Lock()
+    defer toolsMu.Unlock()
+    if _, exists := toolRegistry[tool.Name]; !exists {
+        toolOrder = append(toolOrder, tool.Name)
+    }
+    toolRegistry[tool.Name] = tool
+}
+
+// unregisterServerTools 移除 MCP 服务器之前注册的全部工具
+func unregisterServerTools(server string) {
+    toolsMu.Lock()
+    defer toolsMu.Unlock()
+    order := toolOrder[:0]
+    for _, name := range toolOrder {
+        if toolRegistry[name].Server == server {
+            delete(toolRegistry, name)
+            continue
+        }
+        order = append(order, name)
+    }
+    toolOrder = order
+}
+
+func getTool(name string) *Tool {
+    toolsMu.RLock()
+    defer toolsMu.RUnlock()
+    return toolRegistry[name]
+}
+
+// toolAvailable 判断工具在聊天中是否可用，MCP 工具受服务器白名单和聊天开关控制
+func toolAvailable(tool *Tool, chatID int64) bool {
+    if tool.Server == "" {
+        return true
+    }
+    client := getMCPClient(tool.Server)
+    return client != nil && client.enabledForChat(chatID)
+}
+
+func toolMaxSteps() int {
+    if config.Tools.MaxSteps > 0 {
+        return config.Tools.MaxSteps
+    }
+    return defaultToolMaxSteps
+}
+
+// toolDefinitions 返回聊天中可用的工具定义
+func toolDefinitions(chatID int64) []ToolDefinition {
+    toolsMu.RLock()
+    tools := make([]*Tool, 0, len(toolOrder))
+    for _, name := range toolOrder {
+        tools = append(tools, toolRegistry[name])
+    }
+    toolsMu.RUnlock()
+
+    definitions := make([]ToolDefinition, 0, len(tools))
+    for _, tool := range tools {
+        if !toolAvailable(tool, chatID) {
+            continue
+        }
+        definitions = append(definitions, ToolDefinition{
+            Type: "function",
+            Function: ToolFunction{
+                Name:        tool.Name,
+                Description: tool.Description,
+                Parameters:  tool.Parameters,
+            },
+        })
+    }
+    return definitions
+}
+
+// executeToolCall 执行一次工具调用，返回发回给模型的内容和用于展示的记录
+func executeToolCall(chatID int64, call ToolCall) (string, toolNote) {
+    note := toolNote{Name: call.Function.Name, Arguments: call.Function.Arguments}
+    logEvent("ToolCall", map[string]interface{}{
+        "id":        call.ID,
+        "chatID":    chatID,
+        "name":      call.Function.Name,
+        "arguments": call.Function.Arguments,
+    })
+
+    tool := getTool(call.Function.Name)
+    if tool == nil || !toolAvailable(tool, chatID) {
+        note.Failed = true
+        note.Result = "未知工具"
+        return fmt.Sprintf("Error: unknown tool %q", call.Function.Name), note
+    }
+
+    args := json.RawMessage(call.Function.Arguments)
+    if strings.TrimSpace(call.Function.Arguments) == "" {
+        args = json.RawMessage("{}")
+    }
+
+    result, err := tool.Handler(args)
+    if err != nil {
+        logEvent("ToolCallError", map[string]interface{}{
+            "name":  call.Function.Name,
+            "error": err.Error(),
+        })
+        note.Failed = true
+        note.Result = err.Error()
+        return "Error: " + err.Error(), note
+    }
+
+    // 按字符截断，避免切开多字节字符
+    if truncated := truncateRunes(result, maxToolResultLength); truncated != result {
+        result = truncated + "\n...(truncated)"
+    }
+    note.Result = result
+    return result, note
+}
+
+// formatToolNotes 把工具调用记录渲染成 MarkdownV2 可折叠引用块
+func formatToolNotes(notes []toolNote) string {
+    if len(notes) == 0 {
+        return ""
+    }
+    lines := []string{fmt.Sprintf("🛠 工具调用 ×%d", len(notes))}
+    for _, note := range notes {
+        status := "→"
+        if note.Failed {
+            status = "✗"
+        }
+        line := fmt.Sprintf("%s(%s) %s %s", note.Name, truncateRunes(note.Arguments, maxToolNoteLength), status, truncateRunes(note.Result, maxToolNoteLength))
+        lines = append(lines, strings.Split(line, "\n")...)
+    }
+
+    var sb strings.Builder
+    for i, line := range lines {
+        if i == 0 {
+            sb.WriteString("**>")
+        } else {
+            sb.WriteString(">")
+        }
+        sb.WriteString(escapeMarkdownV2(line))
+        if i < len(lines)-1 {
+            sb.WriteString("\n")
+        }
+    }
+    sb.WriteString("||\n\n")
+    return sb.String()
+}
+
+func truncateRunes(text string, limit int) string {
+    runes := []rune(text)
+    if len(runes) <= limit {
+        return text
+    }
+    return string(runes[:limit]) + "…"
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/json"
+    "fmt"
+    "math"
+    "math/big"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// registerBuiltinTools 按配置注册内置工具，builtin 为空时注册全部
+func registerBuiltinTools() {
+    builtins := []*Tool{
+        {
+            Name:        "get_current_time",
+            Description: "Get the current date and time, optionally in a given IANA timezone such as Asia/Shanghai or America/New_York.",
+            Parameters: map[string]interface{}{
+                "type": "object",
+                "properties": map[string]interface{}{
+                    "timezone": map[string]interface{}{
+                        "type":        "string",
+                        "description": "IANA timezone name. Defaults to the bot's configured timezone.",
+                    },
+                },
+            },
+            Handler: toolCurrentTime,
+        },
+        {
+            Name:        "calculator",
+            Description: "Evaluate a math expression. Supports + - * / % ^, parentheses, constants pi and e, and functions sqrt, abs, sin, cos, tan, asin, acos, atan, ln, log, log2, exp, floor, ceil, round.",
+            Parameters: map[string]interface{}{
+                "type": "object",
+                "properties": map[string]interface{}{
+                    "expression": map[string]interface{}{
+                        "type":        "string",
+                        "description": "The expression to evaluate, e.g. (2+3)*sqrt(16)",
+                    },
+                },
+                "required": []string{"expression"},
+            },
+            Handler: toolCalculator,
+        },
+        {
+            Name:        "convert_units",
+            Description: "Convert a value between units of length, mass, temperature, volume, area, speed, time or digital storage, e.g. km to mi, lb to kg, c to f, gb to mib.",
+            Parameters: map[string]interface{}{
+                "type": "object",
+                "properties": map[string]interface{}{
+                    "value": map[string]interface{}{"type": "number"},
+                    "from":  map[string]interface{}{"type": "string", "description": "Source unit symbol, e.g. km, lb, c, gal"},
+                    "to":    map[string]interface{}{"type": "string", "description": "Target unit symbol"},
+                },
+                "required": []string{"value", "from", "to"},
+            },
+            Handler: toolConvertUnits,
+        },
+        {
+            Name:        "random",
+            Description: "Generate random values: a UUID v4, integers or floats in a range, or random picks from a list of choices.",
+            Parameters: map[string]interface{}{
+                "type": "object",
+                "properties": map[string]interface{}{
+                    "kind": map[string]interface{}{
+                        "type": "string",
+                        "enum": []string{"uuid", "integer", "float", "choice"},
+                    },
+                    "min":     map[string]interface{}{"type": "number", "description": "Lower bound (inclusive) for integer/float"},
+                    "max":     map[string]interface{}{"type": "number", "description": "Upper bound (inclusive for integer) for integer/float"},
+                    "count":   map[string]interface{}{"type": "integer", "description": "How many values to generate, 1-100"},
+                    "choices": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+                },
+                "required": []string{"kind"},
+            },
+            Handler: toolRandom,
+        },
+    }
+
+    enabled := map[string]bool{}
+    for _, name := range config.Tools.Builtin {
+        enabled[name] = true
+    }
+    for _, tool := range builtins {
+        if len(enabled) == 0 || enabled[tool.Name] {
+            registerTool(tool)
+        }
+    }
+}
+
+// defaultLocation 返回配置的时区，未配置时使用系统时区
+func defaultLocation() *time.Location {
+    if config.Timezone != "" {
+        if loc, err := time.LoadLocation(config.Timezone); err == nil {
+            return loc
+        }
+    }
+    return time.Local
+}
+
+func toolCurrentTime(raw json.RawMessage) (string, error) {
+    var args struct {
+        Timezone string `json:"timezone"`
+    }
+    if err := json.Unmarshal(raw, &args); err != nil {
+        return "", fmt.Errorf("invalid arguments: %v", err)
+    }
+    loc := defaultLocation()
+    if args.Timezone != "" {
+        l, err := time.LoadLocation(args.Timezone)
+        if err != nil {
+            return "", fmt.Errorf("unknown timezone %q", args.Timezone)
+        }
+        loc = l
+    }
+    now := time.Now().In(loc)
+    name, offset := now.Zone()
+    result, _ := json.Marshal(map[string]interface{}{
+        "datetime":   now.Format(time.RFC3339),
+        "date":       now.Format("2006-01-02"),
+        "time":       now.Format("15:04:05"),
+        "weekday":    now.Weekday().String(),
+        "timezone":   loc.String(),
+        "abbr":       name,
+        "utc_offset": fmt.Sprintf("%+03d:%02d", offset/3600, abs(offset%3600)/60),
+        "unix":       now.Unix(),
+    })
+    return string(result), nil
+}
+
+func abs(n int) int {
+    if n < 0 {
+        return -n
+    }
+    return n
+}
+
+func toolCalculator(raw json.RawMessage) (string, error) {
+    var args struct {
+        Expression string `json:"expression"`
+    }
+    if err := json.Unmarshal(raw, &args); err != nil {
+        return "", fmt.Errorf("invalid arguments: %v", err)
+    }
+    value, err := evaluateExpression(args.Expression)
+    if err != nil {
+        return "", err
+    }
+    return strconv.FormatFloat(value, 'g', 15, 64), nil
+}
+
+// 计算器：递归下降解析
+type exprParser struct {
+    input []rune
+    pos   int
+}
+
+func evaluateExpression(expr string) (float64, error) {
+    if len(expr) > 1000 {
+        return 0, fmt.Errorf("expression too long")
+    }
+    p := &exprParser{input: []rune(expr)}
+    value, err := p.parseExpr()
+    if err != nil {
+        return 0, err
+    }
+    p.skipSpaces()
+    if p.pos < len(p.input) {
+        return 0, fmt.Errorf("unexpected %q at position %d", string(p.input[p.pos]), p.pos+1)
+    }
+    if math.IsNaN(value) || math.IsInf(value, 0) {
+        return 0, fmt.Errorf("result is not a finite number")
+    }
+    return value, nil
+}
+
+func (p *exprParser) skipSpaces() {
+    for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+        p.pos++
+    }
+}
+
+func (p *exprParser) peek() rune {
+    p.skipSpaces()
+    if p.pos < len(p.input) {
+        return p.input[p.pos]
+    }
+    return 0
+}
+
+func (p *exprParser) parseExpr() (float64, error) {
+    left, err := p.parseTerm()
+    if err != nil {
+        return 0, err
+    }
+    for {
+        switch p.peek() {
+        case '+':
+            p.pos++
+            right, err := p.parseTerm()
+            if err != nil {
+                return 0, err
+            }
+            left += right
+        case '-':
+            p.pos++
+            right, err := p.parseTerm()
+            if err != nil {
+                return 0, err
+            }
+            left -= right
+        default:
+            return left, nil
+        }
+    }
+}
+
+func (p *exprParser) parseTerm() (float64, error) {
+    left, err := p.parseUnary()
+    if err != nil {
+        return 0, err
+    }
+    for {
+        op := p.peek()
+        if op != '*' && op != '/' && op != '%' && op != '×' && op != '÷' {
+            return left, nil
+        }
+        p.pos++
+        right, err := p.parseUnary()
+        if err != nil {
+            return 0, err
+        }
+        switch op {
+        case '*', '×':
+            left *= right
+        case '/', '÷':
+            if right == 0 {
+                return 0, fmt.Errorf("division by zero")
+            }
+            left /= right
+        case '%':
+            if right == 0 {
+                return 0, fmt.Errorf("modulo by zero")
+            }
+            left = math.Mod(left, right)
+        }
+    }
+}
+
+func (p *exprParser) parseUnary() (float64, error) {
+    switch p.peek() {
+    case '-':
+        p.pos++
+        value, err := p.parseUnary()
+        return -value, err
+    case '+':
+        p.pos++
+        return p.parseUnary()
+    }
+    return p.parsePower()
+}
+
+func (p *exprParser) parsePower() (float64, error) {
+    base, err := p.parsePrimary()
+    if err != nil {
+        return 0, err
+    }
+    if p.peek() == '^' {
+        p.pos++
+        exponent, err := p.parseUnary()
+        if err != nil {
+            return 0, err
+        }
+        return math.Pow(base, exponent), nil
+    }
+    return base, nil
+}
+
+func (p *exprParser) parsePrimary() (float64, error) {
+    c := p.peek()
+    switch {
+    case c == '(':
+        p.pos++
+        value, err := p.parseExpr()
+        if err != nil {
+            return 0, err
+        }
+        if p.peek() != ')' {
+            return 0, fmt.Errorf("missing closing parenthesis")
+        }
+        p.pos++
+        return value, nil
+    case unicode.IsDigit(c) || c == '.':
+        start := p.pos
+        for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.' || p.input[p.pos] == '_') {
+            p.pos++
+        }
+        // 科学计数法
+        if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+            next := p.pos + 1
+            if next < len(p.input) && (p.input[next] == '+' || p.input[next] == '-') {
+                next++
+            }
+            if next < len(p.input) && unicode.IsDigit(p.input[next]) {
+                p.pos = next
+                for p.pos < len(p.input) && unicode.IsDigit(p.input[p.pos]) {
+                    p.pos++
+                }
+            }
+        }
+        text := strings.ReplaceAll(string(p.input[start:p.pos]), "_", "")
+        value, err := strconv.ParseFloat(text, 64)
+        if err != nil {
+            return 0, fmt.Errorf("invalid number %q", text)
+        }
+        return value, nil
+    case unicode.IsLetter(c):
+        start := p.pos
+        for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || unicode.IsDigit(p.input[p.pos])) {
+            p.pos++
+        }
+        name := strings.ToLower(string(p.input[start:p.pos]))
+        switch name {
+        case "pi":
+            return math.Pi, nil
+        case "e":
+            return math.E, nil
+        }
+        fn, ok := calculatorFunctions[name]
+        if !ok {
+            return 0, fmt.Errorf("unknown identifier %q", name)
+        }
+        if p.peek() != '(' {
+            return 0, fmt.Errorf("expected ( after %s", name)
+        }
+        arg, err := p.parsePrimary()
+        if err != nil {
+            return 0, err
+        }
+        return fn(arg), nil
+    case c == 0:
+        return 0, fmt.Errorf("unexpected end of expression")
+    }
+    return 0, fmt.Errorf("unexpected %q at position %d", string(c), p.pos+1)
+}
+
+var calculatorFunctions = map[string]func(float64) float64{
+    "sqrt":  math.Sqrt,
+    "abs":   math.Abs,
+    "sin":   math.Sin,
+    "cos":   math.Cos,
+    "tan":   math.Tan,
+    "asin":  math.Asin,
+    "acos":  math.Acos,
+    "atan":  math.Atan,
+    "ln":    math.Log,
+    "log":   math.Log10,
+    "log2":  math.Log2,
+    "exp":   math.Exp,
+    "floor": math.Floor,
+    "ceil":  math.Ceil,
+    "round": math.Round,
+}
+
+// 单位换算：同类单位换算到基准单位的系数
+type unitInfo struct {
+    category string
+    factor   float64
+}
+
+var units = map[string]unitInfo{
+    // 长度，基准：米
+    "m": {"length", 1}, "km": {"length", 1000}, "cm": {"length", 0.01}, "mm": {"length", 0.001},
+    "um": {"length", 1e-6}, "nm": {"length", 1e-9}, "mi": {"length", 1609.344}, "yd": {"length", 0.9144},
+    "ft": {"length", 0.3048}, "in": {"length", 0.0254}, "nmi": {"length", 1852},
+    // 质量，基准：千克
+    "kg": {"mass", 1}, "g": {"mass", 0.001}, "mg": {"mass", 1e-6}, "t": {"mass", 1000},
+    "lb": {"mass", 0.45359237}, "oz": {"mass", 0.028349523125}, "st": {"mass", 6.35029318}, "jin": {"mass", 0.5},
+    // 体积，基准：升
+    "l": {"volume", 1}, "ml": {"volume", 0.001}, "m3": {"volume", 1000}, "cm3": {"volume", 0.001},
+    "gal": {"volume", 3.785411784}, "qt": {"volume", 0.946352946}, "pt": {"volume", 0.473176473},
+    "cup": {"volume", 0.2365882365}, "floz": {"volume", 0.0295735295625},
+    // 面积，基准：平方米
+    "m2": {"area", 1}, "km2": {"area", 1e6}, "cm2": {"area", 1e-4}, "ha": {"area", 1e4},
+    "acre": {"area", 4046.8564224}, "ft2": {"area", 0.09290304}, "mi2": {"area", 2589988.110336}, "mu": {"area", 666.6666667},
+    // 速度，基准：米/秒
+    "m/s": {"speed", 1}, "km/h": {"speed", 1 / 3.6}, "mph": {"speed", 0.44704}, "kn": {"speed", 0.514444},
+    // 时间，基准：秒
+    "ms": {"time", 0.001}, "s": {"time", 1}, "min": {"time", 60}, "h": {"time", 3600},
+    "d": {"time", 86400}, "week": {"time", 604800}, "year": {"time", 31557600},
+    // 存储，基准：字节
+    "b": {"data", 1}, "kb": {"data", 1e3}, "mb": {"data", 1e6}, "gb": {"data", 1e9}, "tb": {"data", 1e12},
+    "kib": {"data", 1024}, "mib": {"data", 1 << 20}, "gib": {"data", 1 << 30}, "tib": {"data", 1 << 40}, "bit": {"data", 0.125},
+}
+
+var unitAliases = map[string]string{
+    "meter": "m", "meters": "m", "kilometer": "km", "kilometers": "km", "mile": "mi", "miles": "mi",
+    "foot": "ft", "feet": "ft", "inch": "in", "inches": "in", "yard": "yd", "yards": "yd",
+    "kilogram": "kg", "kilograms": "kg", "gram": "g", "grams": "g", "pound": "lb", "pounds": "lb", "lbs": "lb",
+    "ounce": "oz", "ounces": "oz", "liter": "l", "liters": "l", "litre": "l", "gallon": "gal", "gallons": "gal",
+    "kmh": "km/h", "kph": "km/h", "mps": "m/s", "knot": "kn", "knots": "kn",
+    "sec": "s", "second": "s", "seconds": "s", "minute": "min", "minutes": "min", "hour": "h", "hours": "h",
+    "day": "d", "days": "d", "weeks": "week", "years": "year", "byte": "b", "bytes": "b",
+    "celsius": "c", "°c": "c", "fahrenheit": "f", "°f": "f", "kelvin": "k",
+}
+
+func normalizeUnit(unit string) string {
+    unit = strings.ToLower(strings.TrimSpace(unit))
+    if alias, ok := unitAliases[unit]; ok {
+        return alias
+    }
+    return unit
+}
+
+func toolConvertUnits(raw json.RawMessage) (string, error) {
+    var args struct {
+        Value float64 `json:"value"`
+        From  string  `json:"from"`
+        To    string  `json:"to"`
+    }
+    if err := json.Unmarshal(raw, &args); err != nil {
+        return "", fmt.Errorf("invalid arguments: %v", err)
+    }
+    value, err := convertUnits(args.Value, normalizeUnit(args.From), normalizeUnit(args.To))
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%s %s = %s %s", strconv.FormatFloat(args.Value, 'g', 12, 64), args.From, strconv.FormatFloat(value, 'g', 12, 64), args.To), nil
+}
+
+func convertUnits(value float64, from, to string) (float64, error) {
+    if isTemperatureUnit(from) || isTemperatureUnit(to) {
+        if !isTemperatureUnit(from) || !isTemperatureUnit(to) {
+            return 0, fmt.Errorf("cannot convert %s to %s", from, to)
+        }
+        var kelvin float64
+        switch from {
+        case "c":
+            kelvin = value + 273.15
+        case "f":
+            kelvin = (value-32)*5/9 + 273.15
+        case "k":
+            kelvin = value
+        }
+        switch to {
+        case "c":
+            return kelvin - 273.15, nil
+        case "f":
+            return (kelvin-273.15)*9/5 + 32, nil
+        default:
+            return kelvin, nil
+        }
+    }
+
+    fromUnit, ok := units[from]
+    if !ok {
+        return 0, fmt.Errorf("unknown unit %q", from)
+    }
+    toUnit, ok := units[to]
+    if !ok {
+        return 0, fmt.Errorf("unknown unit %q", to)
+    }
+    if fromUnit.category != toUnit.category {
+        return 0, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, fromUnit.category, to, toUnit.category)
+    }
+    return value * fromUnit.factor / toUnit.factor, nil
+}
+
+func isTemperatureUnit(unit string) bool {
+    return unit == "c" || unit == "f" || unit == "k"
+}
+
+const maxSafeInteger = 1 << 53
+
+func toolRandom(raw json.RawMessage) (string, error) {
+    var args struct {
+        Kind    string   `json:"kind"`
+        Min     *float64 `json:"min"`
+        Max     *float64 `json:"max"`
+        Count   int      `json:"count"`
+        Choices []string `json:"choices"`
+    }
+    if err := json.Unmarshal(raw, &args); err != nil {
+        return "", fmt.Errorf("invalid arguments: %v", err)
+    }
+    if args.Count <= 0 {
+        args.Count = 1
+    }
+    if args.Count > 100 {
+        return "", fmt.Errorf("count must be between 1 and 100")
+    }
+    low, high := 0.0, 100.0
+    if args.Min != nil {
+        low = *args.Min
+    }
+    if args.Max != nil {
+        high = *args.Max
+    }
+    if args.Kind != "uuid" && args.Kind != "choice" && low > high {
+        return "", fmt.Errorf("min must not be greater than max")
+    }
+
+    var results []string
+    for i := 0; i < args.Count; i++ {
+        switch args.Kind {
+        case "uuid":
+            id, err := newUUID()
+            if err != nil {
+                return "", err
+            }
+            results = append(results, id)
+        case "integer":
+            // 超过 2^53 的浮点数已无法精确表示整数
+            if math.Abs(low) > maxSafeInteger || math.Abs(high) > maxSafeInteger {
+                return "", fmt.Errorf("min and max must be within ±2^53")
+            }
+            lo, hi := int64(math.Ceil(low)), int64(math.Floor(high))
+            span := new(big.Int).Sub(big.NewInt(hi), big.NewInt(lo))
+            span.Add(span, big.NewInt(1))
+            if span.Sign() <= 0 {
+                return "", fmt.Errorf("no integer in range")
+            }
+            n, err := rand.Int(rand.Reader, span)
+            if err != nil {
+                return "", err
+            }
+            results = append(results, strconv.FormatInt(lo+n.Int64(), 10))
+        case "float":
+            n, err := rand.Int(rand.Reader, big.NewInt(1<<53))
+            if err != nil {
+                return "", err
+            }
+            f := low + float64(n.Int64())/float64(1<<53)*(high-low)
+            results = append(results, strconv.FormatFloat(f, 'f', 6, 64))
+        case "choice":
+            if len(args.Choices) == 0 {
+                return "", fmt.Errorf("choices must not be empty")
+            }
+            n, err := rand.Int(rand.Reader, big.NewInt(int64(len(args.Choices))))
+            if err != nil {
+                return "", err
+            }
+            results = append(results, args.Choices[n.Int64()])
+        default:
+            return "", fmt.Errorf("unknown kind %q", args.Kind)
+        }
+    }
+    return strings.Join(results, "\n"), nil
+}
+
+func newUUID() (string, error) {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    b[6] = (b[6] & 0x0f) | 0x40
+    b[8] = (b[8] & 0x3f) | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "html"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+    "syscall"
+    "time"
+
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type URLFetchConfig struct {
+    AutoFetch      bool     `yaml:"auto_fetch"`
+    MaxLinks       int      `yaml:"max_links"`
+    MaxBytes       int64    `yaml:"max_bytes"`
+    MaxChars       int      `yaml:"max_chars"`
+    TimeoutSeconds int      `yaml:"timeout_seconds"`
+    ContentTypes   []string `yaml:"content_types"`
+    AllowedDomains []string `yaml:"allowed_domains"`
+    DeniedDomains  []string `yaml:"denied_domains"`
+    AllowPrivate   bool     `yaml:"allow_private"`
+    SummaryPrompt  string   `yaml:"summary_prompt"`
+}
+
+// 抓取到的网页
+type fetchedPage struct {
+    URL       string
+    Title     string
+    Text      string
+    Truncated bool
+}
+
+var (
+    urlRegex              = regexp.MustCompile(`https?://[^\s<>"'（）【】，。]+`)
+    defaultFetchTypes     = []string{"text/html", "application/xhtml+xml", "text/plain", "text/markdown"}
+    defaultSummaryPrompt  = "请用中文总结以下网页的主要内容，先给出一句话概括，再列出要点。"
+)
+
+func fetchMaxBytes() int64 {
+    if config.URLFetch.MaxBytes > 0 {
+        return config.URLFetch.MaxBytes
+    }
+    return 2 << 20
+}
+
+func fetchMaxChars() int {
+    if config.URLFetch.MaxChars > 0 {
+        return config.URLFetch.MaxChars
+    }
+    return 12000
+}
+
+func fetchMaxLinks() int {
+    if config.URLFetch.MaxLinks > 0 {
+        return config.URLFetch.MaxLinks
+    }
+    return 3
+}
+
+func fetchTimeout() time.Duration {
+    if config.URLFetch.TimeoutSeconds > 0 {
+        return time.Duration(config.URLFetch.TimeoutSeconds) * time.Second
+    }
+    return 15 * time.Second
+}
+
+// extractURLs 从消息文本和实体中提取链接，去重并保持顺序
+func extractURLs(message *tgbotapi.Message) []string {
+    var urls []string
+    seen := map[string]bool{}
+    add := func(u string) {
+        u = strings.TrimRight(u, ".,;:!?)]}")
+        if u != "" && !seen[u] {
+            seen[u] = true
+            urls = append(urls, u)
+        }
+    }
+    for _, entity := range message.Entities {
+        if entity.Type == "text_link" && entity.URL != "" {
+            add(entity.URL)
+        }
+    }
+    for _, u := range urlRegex.FindAllString(message.Text, -1) {
+        add(u)
+    }
+    return urls
+}
+
+func hostMatches(host string, patterns []string) bool {
+    host = strings.ToLower(host)
+    for _, pattern := range patterns {
+        pattern = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(pattern), "*."))
+        if pattern == "" {
+            continue
+        }
+        if host == pattern || strings.HasSuffix(host, "."+pattern) {
+            return true
+        }
+    }
+    return false
+}
+
+// checkFetchURL 校验协议和域名黑白名单
+func checkFetchURL(u *url.URL) error {
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return fmt.Errorf("不支持的协议: %s", u.Scheme)
+    }
+    host := u.Hostname()
+    if host == "" {
+        return fmt.Errorf("无效的链接")
+    }
+    if hostMatches(host, config.URLFetch.DeniedDomains) {
+        return fmt.Errorf("域名 %s 在禁止列表中", host)
+    }
+    if len(config.URLFetch.AllowedDomains) > 0 && !hostMatches(host, config.URLFetch.AllowedDomains) {
+        return fmt.Errorf("域名 %s 不在允许列表中", host)
+    }
+    return nil
+}
+
+// 拒绝连接内网和本机地址，防止通过机器人访问内部服务
+func dialControl(network, address string, c syscall.RawConn) error {
+    if config.URLFetch.AllowPrivate {
+        return nil
+    }
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+    ip := net.ParseIP(host)
+    if ip == nil {
+        return fmt.Errorf("无法解析地址 %s", host)
+    }
+    if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+        return fmt.Errorf("禁止访问内网地址 %s", ip)
+    }
+    return nil
+}
+
+func newFetchClient() *http.Client {
+    dialer := &net.Dialer{
+        Timeout: 10 * time.Second,
+        Control: dialControl,
+    }
+    return &http.Client{
+        Timeout: fetchTimeout(),
+        Transport: &http.Transport{
+            // 经过代理时 dialControl 只能检查代理的地址，因此抓取网页不使用环境变量中的代理
+            Proxy:       nil,
+            DialContext: dialer.DialContext,
+        },
+        CheckRedirect: func(req *http.Request, via []*http.Request) error {
+            if len(via) >= 5 {
+                return fmt.Errorf("重定向次数过多")
+            }
+            return checkFetchURL(req.URL)
+        },
+    }
+}
+
+func contentTypeAllowed(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        mediaType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+    }
+    allowed := config.URLFetch.ContentTypes
+    if len(allowed) == 0 {
+        allowed = defaultFetchTypes
+    }
+    for _, t := range allowed {
+        if strings.EqualFold(mediaType, t) {
+            return true
+        }
+    }
+    return false
+}
+
+// fetchPage 下载网页并提取正文
+func fetchPage(rawURL string) (*fetchedPage, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, fmt.Errorf("无效的链接: %v", err)
+    }
+    if err := checkFetchURL(u); err != nil {
+        return nil, err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout())
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; fyaitg/"+version+")")
+    req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.5")
+
+    resp, err := newFetchClient().Do(req)
+    if err != nil {
+        logEvent("FetchPageError", map[string]interface{}{
+            "url":   rawURL,
+            "error": err.Error(),
+        })
+        return nil, fmt.Errorf("请求失败: %v", err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 400 {
+        return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+    }
+    contentType := resp.Header.Get("Content-Type")
+    if !contentTypeAllowed(contentType) {
+        return nil, fmt.Errorf("不支持的内容类型: %s", contentType)
+    }
+    if resp.ContentLength > fetchMaxBytes() {
+        return nil, fmt.Errorf("页面过大: %d 字节，上限 %d 字节", resp.ContentLength, fetchMaxBytes())
+    }
+
+    body, err := ioutil.ReadAll(io.LimitReader(resp.Body, fetchMaxBytes()+1))
+    if err != nil {
+        return nil, fmt.Errorf("读取失败: %v", err)
+    }
+    truncated := false
+    if int64(len(body)) > fetchMaxBytes() {
+        body = body[:fetchMaxBytes()]
+        truncated = true
+    }
+
+    page := &fetchedPage{URL: resp.Request.URL.String()}
+    content := strings.ToValidUTF8(string(body), "")
+    if strings.Contains(contentType, "html") {
+        page.Title, page.Text = extractReadableText(content)
+    } else {
+        page.Text = strings.TrimSpace(content)
+    }
+
+    runes := []rune(page.Text)
+    if len(runes) > fetchMaxChars() {
+        page.Text = string(runes[:fetchMaxChars()])
+        truncated = true
+    }
+    page.Truncated = truncated
+
+    logEvent("PageFetched", map[string]interface{}{
+        "url":       page.URL,
+        "title":     page.Title,
+        "chars":     len([]rune(page.Text)),
+        "truncated": page.Truncated,
+    })
+    return page, nil
+}
+
+var (
+    htmlTagRegex       = regexp.MustCompile(`(?s)<!--.*?-->|<(/?)([a-zA-Z][a-zA-Z0-9]*)([^>]*)>`)
+    htmlTitleRegex     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+    boilerplateAttr    = regexp.MustCompile(`(?i)(class|id|role)\s*=\s*["'][^"']*(nav|menu|footer|header|sidebar|comment|cookie|banner|advert|share|social|breadcrumb|related|popup|modal|subscribe)[^"']*["']`)
+    hiddenAttr         = regexp.MustCompile(`(?i)\shidden(\s|=|$)|aria-hidden\s*=\s*["']true["']|display\s*:\s*none`)
+    blankLinesRegex    = regexp.MustCompile(`\n{3,}`)
+    spacesRegex        = regexp.MustCompile(`[ \t\x{00a0}]+`)
+)
+
+// 整个子树都会被丢弃的标签
+var skipTags = map[string]bool{
+    "script": true, "style": true, "noscript": true, "svg": true, "canvas": true, "iframe": true,
+    "nav": true, "header": true, "footer": true, "aside": true, "form": true, "button": true,
+    "select": true, "template": true, "head": true,
+}
+
+var voidTags = map[string]bool{
+    "br": true, "img": true, "hr": true, "meta": true, "link": true, "input": true,
+    "area": true, "base": true, "col": true, "embed": true, "source": true, "track": true, "wbr": true,
+}
+
+var blockTags = map[string]bool{
+    "p": true, "div": true, "section": true, "article": true, "main": true, "li": true, "ul": true, "ol": true,
+    "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "tr": true, "table": true,
+    "blockquote": true, "pre": true, "br": true, "hr": true, "dd": true, "dt": true, "figcaption": true,
+}
+
+type htmlFrame struct {
+    tag  string
+    skip bool
+    main bool
+}
+
+// extractReadableText 去除导航、页眉页脚、脚本等样板内容，优先返回 article/main 中的正文
+func extractReadableText(doc string) (string, string) {
+    title := ""
+    if m := htmlTitleRegex.FindStringSubmatch(doc); m != nil {
+        title = strings.TrimSpace(html.UnescapeString(m[1]))
+    }
+
+    var all, main strings.Builder
+    var stack []htmlFrame
+    skipDepth, mainDepth := 0, 0
+
+    emit := func(text string) {
+        if skipDepth > 0 {
+            return
+        }
+        all.WriteString(text)
+        if mainDepth > 0 {
+            main.WriteString(text)
+        }
+    }
+
+    last := 0
+    for _, loc := range htmlTagRegex.FindAllStringSubmatchIndex(doc, -1) {
+        if loc[0] < last {
+            // 位于已跳过的 script/style 内容中
+            continue
+        }
+        emit(html.UnescapeString(doc[last:loc[0]]))
+        last = loc[1]
+        if loc[4] < 0 {
+            // 注释
+            continue
+        }
+        closing := doc[loc[2]:loc[3]] == "/"
+        tag := strings.ToLower(doc[loc[4]:loc[5]])
+        attrs := doc[loc[6]:loc[7]]
+
+        if blockTags[tag] {
+            emit("\n")
+        }
+        if closing {
+            for i := len(stack) - 1; i >= 0; i-- {
+                if stack[i].tag != tag {
+                    continue
+                }
+                for j := len(stack) - 1; j >= i; j-- {
+                    if stack[j].skip {
+                        skipDepth--
+                    }
+                    if stack[j].main {
+                        mainDepth--
+                    }
+                }
+                stack = stack[:i]
+                break
+            }
+            continue
+        }
+        if voidTags[tag] || strings.HasSuffix(strings.TrimSpace(attrs), "/") {
+            continue
+        }
+
+        frame := htmlFrame{tag: tag}
+        if skipTags[tag] || boilerplateAttr.MatchString(attrs) || hiddenAttr.MatchString(attrs) {
+            frame.skip = true
+            skipDepth++
+        }
+        if tag == "article" || tag == "main" {
+            frame.main = true
+            mainDepth++
+        }
+        stack = append(stack, frame)
+
+        // script/style 内容可能包含 "<"，直接跳到结束标签
+        if tag == "script" || tag == "style" {
+            end := strings.Index(strings.ToLower(doc[last:]), "</"+tag)
+            if end >= 0 {
+                last += end
+            }
+        }
+    }
+    emit(html.UnescapeString(doc[last:]))
+
+    text := all.String()
+    if mainText := main.String(); len([]rune(strings.TrimSpace(mainText))) >= 200 {
+        text = mainText
+    }
+    return title, cleanExtractedText(text)
+}
+
+func cleanExtractedText(text string) string {
+    lines := strings.Split(text, "\n")
+    seen := map[string]bool{}
+    var kept []string
+    for _, line := range lines {
+        line = strings.TrimSpace(spacesRegex.ReplaceAllString(line, " "))
+        if line == "" {
+            kept = append(kept, "")
+            continue
+        }
+        // 重复出现的短行通常是菜单或版权等样板
+        if len([]rune(line)) < 40 && seen[line] {
+            continue
+        }
+        seen[line] = true
+        kept = append(kept, line)
+    }
+    return strings.TrimSpace(blankLinesRegex.ReplaceAllString(strings.Join(kept, "\n"), "\n\n"))
+}
+
+// formatPageContext 把网页内容格式化为附加到对话中的上下文
+func formatPageContext(index int, page *fetchedPage) string {
+    var sb strings.Builder
+    sb.WriteString(fmt.Sprintf("[网页内容 %d] %s\n来源: %s\n", index, page.Title, page.URL))
+    sb.WriteString(page.Text)
+    if page.Truncated {
+        sb.WriteString("\n（内容过长，已截断）")
+    }
+    return sb.String()
+}
+
+// linkedPagesContext 在自动抓取模式下抓取消息中的链接，返回附加到本次请求的网页内容
+func linkedPagesContext(message *tgbotapi.Message) string {
+    if !config.URLFetch.AutoFetch {
+        return ""
+    }
+    urls := extractURLs(message)
+    if len(urls) == 0 {
+        return ""
+    }
+    if len(urls) > fetchMaxLinks() {
+        urls = urls[:fetchMaxLinks()]
+    }
+
+    var contexts []string
+    for i, u := range urls {
+        page, err := fetchPage(u)
+        if err != nil {
+            contexts = append(contexts, fmt.Sprintf("[网页内容 %d] 无法获取 %s：%v", i+1, u, err))
+            continue
+        }
+        contexts = append(contexts, formatPageContext(i+1, page))
+    }
+    return "\n\n---\n以下是消息中链接的网页内容，供回答参考：\n\n" + strings.Join(contexts, "\n\n")
+}
+
+// summarizeURL 处理 /summarize 命令
+func summarizeURL(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+    args := strings.TrimSpace(message.CommandArguments())
+    target := urlRegex.FindString(args)
+    if target == "" && message.ReplyToMessage != nil {
+        if urls := extractURLs(message.ReplyToMessage); len(urls) > 0 {
+            target = urls[0]
+        }
+    }
+    if target == "" {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, "用法：/summarize <链接>，或回复一条包含链接的消息"), messageThreadID(message))
+        return
+    }
+
+    role := messageRole(message)
+    model, ok := roleModel(bot, message, role, sessionModel(sessionKeyFor(message)))
+    if !ok || !consumeQuota(bot, message) {
+        return
+    }
+
+    start := time.Now()
+    page, err := fetchPage(target)
+    if err != nil {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("无法获取网页：%v", err)), messageThreadID(message))
+        return
+    }
+    if page.Text == "" {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, "网页中没有可提取的正文内容"), messageThreadID(message))
+        return
+    }
+
+    prompt := config.URLFetch.SummaryPrompt
+    if prompt == "" {
+        prompt = defaultSummaryPrompt
+    }
+    messages := []Message{
+        {Role: "system", Content: prompt, Time: time.Now()},
+        {Role: "user", Content: formatPageContext(1, page), Time: time.Now()},
+    }
+    result, err := callOpenAIWithRetry(completionRequest{ChatID: message.Chat.ID, Model: model, Messages: messages, NoTools: true, Role: role})
+    if err != nil {
+        sendThreaded(bot, tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("抱歉，总结失败：%v", err)), messageThreadID(message))
+        return
+    }
+    recordUsage(bot, message, result)
+
+    title := page.Title
+    if title == "" {
+        title = page.URL
+    }
+    header := fmt.Sprintf("📄 网页摘要：%s\n🔗 %s\n⏱ %.2f秒\n\n", title, page.URL, time.Since(start).Seconds())
+    msg := tgbotapi.NewMessage(message.Chat.ID, escapeMarkdownV2(header)+mdToTgmd(result.Content))
+    msg.ParseMode = "MarkdownV2"
+    msg.DisableWebPagePreview = true
+    if _, err := sendThreaded(bot, msg, messageThreadID(message)); err != nil {
+        logEvent("SendSummaryError", err)
+        plain := tgbotapi.NewMessage(message.Chat.ID, header+result.Content)
+        plain.DisableWebPagePreview = true
+        sendThreaded(bot, plain, messageThreadID(message))
+    }
+}